@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// completionTimeout limits the API calls made while completing so a slow or
+// unreachable cluster doesn't hang the shell.
+const completionTimeout = 5 * time.Second
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeDeployments completes the [deployment/]{name} argument with the
+// deployments in the namespace. With devpods only the devpod copies are listed,
+// otherwise only the deployments that aren't one.
+func completeDeployments(global *globalOptions, devpods bool) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		clientset, namespace, err := global.connect()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+		list, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		// A bare name is taken as a pod, so the resource is always completed
+		// too unless one was typed already.
+		prefix := "deployment/"
+		if resource, _, ok := strings.Cut(toComplete, "/"); ok {
+			prefix = resource + "/"
+		}
+		names := []string{}
+		for _, dp := range list.Items {
+			if strings.HasSuffix(dp.Name, "-devpod") != devpods {
+				continue
+			}
+			names = append(names, prefix+dp.Name)
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeContainers completes --container and --sidecar with the containers of
+// the deployment given as the argument.
+func completeContainers(global *globalOptions) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) < 1 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		clientset, namespace, err := global.connect()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+		_, name := parseResourceArg(args[0])
+		dp, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		names := []string{}
+		for _, container := range dp.Spec.Template.Spec.Containers {
+			names = append(names, container.Name)
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeNamespaces completes --namespace with the namespaces of the cluster.
+func completeNamespaces(global *globalOptions) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		clientset, err := global.Client.Clientset()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+		list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		names := []string{}
+		for _, ns := range list.Items {
+			names = append(names, ns.Name)
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
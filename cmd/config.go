@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// DevpodConfig is the user config file, it holds defaults for the command line
+// flags so opinionated settings don't have to be passed every time.
+type DevpodConfig struct {
+	// Flags maps a flag name, without the leading dashes, to its default. A
+	// list sets every item, like repeating the flag, and is added to by the
+	// same flag on the command line. A map is set as key=value pairs, e.g.
+	// for --node-selector.
+	Flags map[string]interface{} `json:"flags"`
+}
+
+// configPath returns $XDG_CONFIG_HOME/devpod/config.yaml, falling back to
+// ~/.config/devpod/config.yaml.
+func configPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "devpod", "config.yaml"), nil
+}
+
+// loadConfig reads the config file at path, a missing file is an empty config.
+func loadConfig(path string) (*DevpodConfig, error) {
+	config := &DevpodConfig{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// applyConfig sets the defaults from config on the flags of flagSets. It must
+// be called before envy and cobra parse so the environment and the command
+// line take precedence, the same way envy sets them.
+func applyConfig(config *DevpodConfig, flagSets ...*pflag.FlagSet) error {
+	for name, val := range config.Flags {
+		var f *pflag.Flag
+		for _, fs := range flagSets {
+			if f = fs.Lookup(name); f != nil {
+				break
+			}
+		}
+		if f == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		vals, ok := val.([]interface{})
+		if !ok {
+			vals = []interface{}{val}
+		}
+		for _, item := range vals {
+			value, err := configValue(item)
+			if err != nil {
+				return fmt.Errorf("invalid value for flag %q: %w", name, err)
+			}
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid value %v for flag %q: %w", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValue formats a value of the config file the way it's passed on the
+// command line. Maps become comma separated key=value pairs, quoted like the
+// string to string flags expect when a pair contains a comma.
+func configValue(val interface{}) (string, error) {
+	items, ok := val.(map[string]interface{})
+	if !ok {
+		return scalarValue(val), nil
+	}
+	pairs := make([]string, 0, len(items))
+	for key, item := range items {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, scalarValue(item)))
+	}
+	// Keep the order stable so errors are reproducible.
+	sort.Strings(pairs)
+	var line strings.Builder
+	w := csv.NewWriter(&line)
+	if err := w.Write(pairs); err != nil {
+		return "", err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line.String(), "\n"), nil
+}
+
+// scalarValue formats a single value of the config file. The YAML decoder
+// turns every number into a float64, which fmt prints in exponent notation
+// once it's large, e.g. 1e+06 for 1000000, which no int flag accepts.
+func scalarValue(val interface{}) string {
+	if number, ok := val.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(val)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// loadTestConfig writes content to a config file and loads it.
+func loadTestConfig(t *testing.T, content string) *DevpodConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	return config
+}
+
+func TestApplyConfig(t *testing.T) {
+	config := loadTestConfig(t, `flags:
+  node-selector:
+    gpu: "true"
+    zone: us-east-1a
+  image-registry-mirror:
+    docker.io: mirror.internal/docker
+  add-resource-request:
+    nvidia.com/gpu: 1
+    memory: 2000000000
+  label:
+    team: a,b
+  container:
+  - app
+  - worker
+  privileged: true
+  max-history: 3
+  hpa-max-replicas: 1000000
+`)
+	var nodeSelector, mirrors, requests, labels map[string]string
+	var containers []string
+	var privileged bool
+	var maxHistory, hpaMaxReplicas int32
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringToStringVar(&nodeSelector, "node-selector", nil, "")
+	flags.StringToStringVar(&mirrors, "image-registry-mirror", nil, "")
+	flags.StringToStringVar(&requests, "add-resource-request", nil, "")
+	flags.StringToStringVar(&labels, "label", nil, "")
+	flags.StringSliceVar(&containers, "container", nil, "")
+	flags.BoolVar(&privileged, "privileged", false, "")
+	flags.Int32Var(&maxHistory, "max-history", 0, "")
+	flags.Int32Var(&hpaMaxReplicas, "hpa-max-replicas", 0, "")
+
+	if err := applyConfig(config, flags); err != nil {
+		t.Fatalf("applyConfig() error = %v", err)
+	}
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"node-selector", nodeSelector, map[string]string{"gpu": "true", "zone": "us-east-1a"}},
+		{"image-registry-mirror", mirrors, map[string]string{"docker.io": "mirror.internal/docker"}},
+		{"add-resource-request", requests, map[string]string{"nvidia.com/gpu": "1", "memory": "2000000000"}},
+		{"label", labels, map[string]string{"team": "a,b"}},
+		{"container", containers, []string{"app", "worker"}},
+		{"privileged", privileged, true},
+		{"max-history", maxHistory, int32(3)},
+		{"hpa-max-replicas", hpaMaxReplicas, int32(1000000)},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("--%s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestApplyConfigUnknownFlag(t *testing.T) {
+	config := loadTestConfig(t, "flags:\n  no-such-flag: true\n")
+	if err := applyConfig(config, pflag.NewFlagSet("test", pflag.ContinueOnError)); err == nil {
+		t.Error("applyConfig() didn't fail for an unknown flag")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fernferret/devpod/pkg/devpod"
+	"sigs.k8s.io/yaml"
+)
+
+// printManifest writes obj to stdout as a YAML document so it can be piped
+// straight into kubectl or committed to a repo.
+func printManifest(obj interface{}) error {
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "---\n%s", out)
+	return nil
+}
+
+// writeFile writes a generated file, creating its directory if needed.
+func writeFile(file *devpod.File) error {
+	if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file.Path, file.Data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", file.Path)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fernferret/devpod/pkg/devpod"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+)
+
+// showList prints the devpods in namespace. With allNamespaces every namespace
+// is searched instead, or only the ones matching namespaceSelector if it's set.
+func showList(ctx context.Context, clientset kubernetes.Interface, namespace string, allNamespaces bool, namespaceSelector string) {
+	namespaces := []string{namespace}
+	if allNamespaces {
+		namespaces = []string{metav1.NamespaceAll}
+		if namespaceSelector != "" {
+			var err error
+			namespaces, err = devpod.NamespacesMatching(ctx, clientset, namespaceSelector)
+			if err != nil {
+				fatal(ctx, err)
+			}
+		}
+	}
+
+	devpods := []appsv1.Deployment{}
+	for _, ns := range namespaces {
+		found, err := devpod.List(ctx, clientset, ns)
+		if err != nil {
+			fatal(ctx, err)
+		}
+		devpods = append(devpods, found...)
+	}
+
+	if len(devpods) == 0 {
+		if allNamespaces {
+			fmt.Fprintf(os.Stderr, "No devpods found\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "No devpods found in namespace %q\n", namespace)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tREADY\tPAUSED\tAGE")
+	for _, dp := range devpods {
+		age := duration.HumanDuration(time.Since(dp.CreationTimestamp.Time))
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%t\t%s\n", dp.Namespace, dp.Name, dp.Status.ReadyReplicas, dp.Status.Replicas, dp.Spec.Paused, age)
+	}
+	w.Flush()
+}
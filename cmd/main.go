@@ -2,332 +2,787 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"sort"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
-	"github.com/containers/image/v5/image"
-	"github.com/containers/image/v5/transports/alltransports"
-	"github.com/containers/image/v5/types"
+	"github.com/fernferret/devpod/pkg/devpod"
+	"github.com/fernferret/devpod/pkg/k8s"
 	"github.com/fernferret/envy"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
-	//
-	// Uncomment to load all auth plugins
-	// _ "k8s.io/client-go/plugin/pkg/client/auth"
-	//
-	// Or uncomment to load specific auth plugins
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/azure"
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "usage: %s [deployment/]{name}:\n", os.Args[0])
-	pflag.PrintDefaults()
+// globalOptions are the flags shared by the devpod command and every
+// subcommand.
+type globalOptions struct {
+	Namespace           string
+	UseContextNamespace bool
+	Timeout             time.Duration
+	Trace               bool
+	OTelEndpoint        string
+	Client              k8s.ClientOptions
 }
 
-func parseImageSource(ctx context.Context, name string) (types.ImageSource, error) {
-	ref, err := alltransports.ParseImageName(name)
+// connect creates the clientset and resolves the namespace the command works
+// in.
+func (g *globalOptions) connect() (kubernetes.Interface, string, error) {
+	clientset, err := g.Client.Clientset()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	sys := &types.SystemContext{}
-	return ref.NewImageSource(ctx, sys)
-}
-
-type ImageInfo struct {
-	Cmd        []string
-	Entrypoint []string
-	WorkingDir string
-}
-
-func inspectImage(imageName string) (*ImageInfo, error) {
-	ctx := context.Background()
-	sys := &types.SystemContext{}
-	src, err := parseImageSource(ctx, imageName)
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing image source: %w", err)
-	}
-	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	namespace, err := g.Client.ResolveNamespace(g.Namespace, g.UseContextNamespace)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing manifest for image: %w", err)
+		return nil, "", fmt.Errorf("unable to determine the namespace, use --namespace to set it: %w", err)
 	}
-	myImg, err := img.OCIConfig(ctx)
-	// imgInspect, err := img.Inspect(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("Error inspecting image: %w", err)
-	}
-	return &ImageInfo{
-		WorkingDir: myImg.Config.WorkingDir,
-		Entrypoint: myImg.Config.Entrypoint,
-		Cmd:        myImg.Config.Cmd,
-	}, nil
+	return clientset, namespace, nil
 }
 
-func loadCurrentNamespace(kubeconfig string) (string, error) {
-	kubectlconfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
-		&clientcmd.ConfigOverrides{
-			CurrentContext: "",
-		}).RawConfig()
+// mustConnect is connect for the commands themselves, it exits on errors.
+func (g *globalOptions) mustConnect() (kubernetes.Interface, string) {
+	clientset, namespace, err := g.connect()
 	if err != nil {
-		return "", err
-	}
-	currentContext := kubectlconfig.CurrentContext
-	ctx, ok := kubectlconfig.Contexts[currentContext]
-	if !ok {
-		return "", fmt.Errorf("current context %q from kubeconfig %q not found, this is a misconfiguration on your part", currentContext, kubeconfig)
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
 	}
-	return ctx.Namespace, nil
+	return clientset, namespace
 }
 
 func main() {
-	pflag.Usage = usage
-	var kubeconfig, namespace, skopeoTransport string
-	var force bool
-	pflag.StringVarP(&namespace, "namespace", "n", "", "If present, the `namespace` scope for this CLI request")
-	pflag.StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file, KUBECONFIG will be used if absent")
-	pflag.BoolVarP(&force, "force", "f", false, "remove an old devpod if it existed")
-	pflag.StringVar(&skopeoTransport, "skopeo-transport", "docker://", "set the transport to use when looking up remote container information")
-	// nameTemplate := pflag.String("name", "%s-devpod", "Set a name template to create the new resource")
-
-	envy.SetEnvName("kubeconfig", "KUBECONFIG")
-	envy.Parse("DEVPOD")
-	pflag.Parse()
-
-	if len(pflag.Args()) < 1 {
-		fmt.Fprintf(os.Stderr, "ERROR: missing 'name' argument, see --help.\n")
-		os.Exit(1)
-	}
+	var refreshInterval time.Duration
+	var keepTopologySpread bool
+	var keepHPA bool
+	var watchSource bool
+	var labelSelector string
+	var installType string
+	var allNamespaces bool
+	var namespaceLabelSelector string
+	global := &globalOptions{}
+	opts := &devpod.Options{Log: os.Stderr}
+	createOpts := &createOptions{}
+
+	// checkCreateFlags exits on create flags that conflict and applies the
+	// ones that are only aliases, it's shared by create and import.
+	checkCreateFlags := func() {
+		if createOpts.ScriptsOnly && createOpts.SaveScripts == "" {
+			fmt.Fprintf(os.Stderr, "ERROR: --scripts-only needs --save-scripts to know where to write them\n")
+			os.Exit(1)
+		}
 
-	// Load the kubeconfig first from the command line, then from KUBECONFIG (via
-	// envy). If we still don't have one, try to set it from the homedir.
-	if kubeconfig == "" {
-		if home := homedir.HomeDir(); home != "" {
-			kubeconfig = filepath.Join(home, ".kube", "config")
+		if (createOpts.SaveScripts != "" || createOpts.ShowScripts) && opts.NoConfigMap {
+			fmt.Fprintf(os.Stderr, "ERROR: --no-configmap doesn't generate any init scripts for --save-scripts or --show-scripts\n")
+			os.Exit(1)
 		}
-	}
 
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		panic(err.Error())
-	}
+		if createOpts.Progress && !createOpts.Wait {
+			fmt.Fprintf(os.Stderr, "ERROR: --progress streams the pod events while waiting, it needs --wait\n")
+			os.Exit(1)
+		}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
-	}
+		if opts.CreateOnly && opts.UpdateOnly {
+			fmt.Fprintf(os.Stderr, "ERROR: --create-only and --update-only can't be used together\n")
+			os.Exit(1)
+		}
 
-	if namespace == "" {
-		namespace, err = loadCurrentNamespace(kubeconfig)
-		if err != nil {
-			panic(err.Error())
+		// Every change of the source recreates the devpod that was just
+		// created.
+		if opts.CreateOnly && watchSource {
+			fmt.Fprintf(os.Stderr, "ERROR: --watch recreates the devpod when the source changes, it can't be used with --create-only\n")
+			os.Exit(1)
 		}
-	}
 
-	name := pflag.Arg(0)
-	resource := "pod"
-	if strings.Contains(name, "/") {
-		splitList := strings.SplitN(name, "/", 2)
-		resource = strings.ToLower(splitList[0])
-		name = splitList[1]
-	}
+		if keepTopologySpread {
+			opts.StripTopologySpread = false
+		}
 
-	switch resource {
-	// case "pod", "pods", "po":
-	case "deployment", "deployments", "deploy", "dp":
-		createDeployment(clientset, name, "deployment", namespace, skopeoTransport, force)
-	// case "statefulset", "statefulsets", "sts":
-	default:
-		fmt.Fprintf(os.Stderr, "ERROR: unrecognized resource type: %q, see --help for info. Only standard kubernetes types are supported.\n", resource)
-		os.Exit(1)
+		if keepHPA {
+			opts.CopyHPA = true
+		}
 	}
-}
 
-func createInitContainer(pod *v1.PodSpec, resource, namespace, name, skopeoTransport string) *v1.ConfigMap {
-	cm := v1.ConfigMap{}
-	cm.Name = fmt.Sprintf("%s-devpod-init", name)
-	cm.Namespace = namespace
-	cm.Data = map[string]string{}
-	for idx, item := range pod.Containers {
-		imageDetails, _ := inspectImage(fmt.Sprintf("%s%s", skopeoTransport, item.Image))
-		containerName := item.Name
-		filename := fmt.Sprintf("%d_%s.sh", idx, containerName)
-		script := "#!/bin/sh\n\n"
-		if item.WorkingDir != "" {
-			script = fmt.Sprintf("%secho 'Setting WorkingDir via: cd %s';\n", script, item.WorkingDir)
-			script = fmt.Sprintf("%scd %s;\n\n", script, item.WorkingDir)
+	// create is both the create subcommand and the root command, so the
+	// devpod [deployment/]{name} form keeps working.
+	create := func(cmd *cobra.Command, args []string) {
+		checkCreateFlags()
+
+		if len(args) < 1 && labelSelector == "" && opts.FromFile == "" {
+			fmt.Fprintf(os.Stderr, "ERROR: missing 'name' argument, see --help.\n")
+			os.Exit(1)
 		}
-		var savedArgs, savedCmd []string
 
-		var lineInScript []string
+		if labelSelector != "" && opts.FromFile != "" {
+			fmt.Fprintf(os.Stderr, "ERROR: --label-selector and --from-file can't be used together\n")
+			os.Exit(1)
+		}
 
-		if len(item.Command) > 0 {
-			copy(savedCmd, item.Command)
-			script = fmt.Sprintf("%s# Command (ENTRYPOINT) from container:\n# %s\n", script, strings.Join(item.Command, " "))
-			lineInScript = item.Command
+		if watchSource && (opts.FromFile != "" || createOpts.ScriptsOnly || opts.GeneratesManifests()) {
+			fmt.Fprintf(os.Stderr, "ERROR: --watch recreates the devpod in the cluster, it can't be used with --from-file, --scripts-only or the --generate-* flags\n")
+			os.Exit(1)
 		}
-		if len(imageDetails.Entrypoint) > 0 {
-			script = fmt.Sprintf("%s# Command (ENTRYPOINT) from image:\n# %s\n", script, strings.Join(imageDetails.Entrypoint, " "))
-			if len(item.Command) == 0 {
-				lineInScript = imageDetails.Entrypoint
+
+		createOpts.Kubeconfig = global.Client.Kubeconfig
+		createOpts.Context = global.Client.Context
+
+		var clientset kubernetes.Interface
+		namespace := global.Namespace
+		if opts.FromFile != "" && (createOpts.ScriptsOnly || opts.GeneratesManifests()) {
+			// Nothing is read from or written to the cluster, so it doesn't
+			// have to be reachable.
+			if namespace == "" {
+				namespace = "default"
 			}
+		} else {
+			clientset, namespace = global.mustConnect()
 		}
 
-		if len(item.Args) > 0 {
-			copy(savedArgs, item.Args)
-			script = fmt.Sprintf("%s# Args (CMD) from container:\n# %s\n", script, strings.Join(item.Args, " "))
-			lineInScript = append(lineInScript, item.Args...)
+		if opts.GenerateKubeconfig {
+			config, err := global.Client.RESTConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: unable to load the cluster config for --generate-kubeconfig-for-devpod: %s\n", err)
+				os.Exit(1)
+			}
+			createOpts.Server = config.Host
 		}
-		if len(imageDetails.Cmd) > 0 {
-			script = fmt.Sprintf("%s# Args (CMD) from image:\n# %s\n", script, strings.Join(imageDetails.Cmd, " "))
-			if len(item.Args) == 0 {
-				lineInScript = append(lineInScript, imageDetails.Cmd...)
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), global.Timeout)
+		defer cancel()
+		ctx, span := otel.Tracer("github.com/fernferret/devpod/cmd").Start(ctx, "create")
+		defer span.End()
+
+		if labelSelector != "" {
+			if len(args) > 0 {
+				fmt.Fprintf(os.Stderr, "ERROR: a name can't be given with --label-selector\n")
+				os.Exit(1)
+			}
+			name, err := devpod.FindDeployment(ctx, clientset, labelSelector, namespace)
+			if err != nil {
+				fatal(ctx, err)
 			}
+			if err := createDevpod(ctx, clientset, name, "deployment", namespace, opts, createOpts); err != nil {
+				fatal(ctx, err)
+			}
+			if watchSource {
+				watchDevpod(cmd.Context(), clientset, name, namespace, global.Timeout, opts, createOpts)
+			}
+			return
 		}
-		script = fmt.Sprintf("%s\n%s\n", script, strings.Join(lineInScript, " "))
 
-		cm.Data[filename] = script
-		item.Command = []string{
-			"sh",
-			"-c",
+		// The name picks the deployment in --from-file, the first one is used
+		// without it.
+		resource, name := "deployment", ""
+		if len(args) > 0 {
+			resource, name = parseResourceArg(args[0])
 		}
-		item.Args = []string{
-			fmt.Sprintf(`echo "Welcome to DEVPOD"
-echo "This is a copy of the %s %s/%s"
-echo "All it does is just sleep forever and ever"
-echo ""
-echo "The existing entrypoint was combined and placed: TODO"
-
-sleep infinity`, resource, namespace, name),
+
+		switch resource {
+		// case "pod", "pods", "po":
+		case "deployment", "deployments", "deploy", "dp":
+			if err := createDevpod(ctx, clientset, name, "deployment", namespace, opts, createOpts); err != nil {
+				fatal(ctx, err)
+			}
+			if watchSource {
+				watchDevpod(cmd.Context(), clientset, name, namespace, global.Timeout, opts, createOpts)
+			}
+		// case "statefulset", "statefulsets", "sts":
+		default:
+			fmt.Fprintf(os.Stderr, "ERROR: unrecognized resource type: %q, see --help for info. Only standard kubernetes types are supported.\n", resource)
+			os.Exit(1)
 		}
-		pod.Containers[idx] = item
 	}
 
-	return &cm
-}
+	root := &cobra.Command{
+		Use:               "devpod [deployment/]{name}",
+		Short:             "Create a copy of a deployment whose containers sleep instead, for debugging",
+		Long:              "Create a copy of a deployment whose containers sleep instead, for debugging.\n\nWithout a subcommand devpod runs create.",
+		Example:           "  devpod deployment/api\n  devpod create deployment/api\n  devpod create --label-selector app=api",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeDeployments(global, false),
+		Run:               create,
+		// Every subcommand runs this first, tracing is set up here so their
+		// spans are exported too.
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if !global.Trace && global.OTelEndpoint == "" {
+				return
+			}
+			if err := setupTracing(cmd.Context(), global.OTelEndpoint); err != nil {
+				fatal(cmd.Context(), err)
+			}
+		},
+	}
 
-func createDeployment(clientset *kubernetes.Clientset, name, resource, namespace, skopeoTransport string, force bool) {
-	dp, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to find %s %q in namespace %q, cannot create devpod: %s\n", resource, name, namespace, err)
-		os.Exit(1)
+	createCmd := &cobra.Command{
+		Use:               "create [deployment/]{name}",
+		Short:             "Create or update the devpod of a deployment",
+		Example:           "  devpod create deployment/api\n  devpod create --label-selector app=api",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeDeployments(global, false),
+		Run:               create,
 	}
 
-	// Check for an existing devpod to at least get its UID
-	newName := fmt.Sprintf("%s-devpod", dp.Name)
-	newDp, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), newName, metav1.GetOptions{})
-	if err != nil {
-		if !k8serr.IsNotFound(err) {
-			fmt.Fprintf(os.Stderr, "Unable to search for %s %q in namespace %q, cannot create devpod: %s\n", resource, name, namespace, err)
+	importCmd := &cobra.Command{
+		Use:     "import {context} [deployment/]{name}",
+		Short:   "Create the devpod of a deployment in another cluster in the current one",
+		Long:    "Create the devpod of a deployment in another cluster in the current one.\n\nThe deployment is read with the kubeconfig context given as the first argument, the devpod is created with the current context or --context.",
+		Example: "  devpod import staging deployment/api\n  devpod import staging -n api deployment/api",
+		Args:    cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			checkCreateFlags()
+			if labelSelector != "" || opts.FromFile != "" || watchSource {
+				fmt.Fprintf(os.Stderr, "ERROR: --label-selector, --from-file and --watch can't be used with import\n")
+				os.Exit(1)
+			}
+			resource, name := parseResourceArg(args[1])
+			switch resource {
+			case "deployment", "deployments", "deploy", "dp":
+			default:
+				fmt.Fprintf(os.Stderr, "ERROR: unrecognized resource type: %q, only deployments can be imported\n", resource)
+				os.Exit(1)
+			}
+
+			createOpts.Kubeconfig = global.Client.Kubeconfig
+			createOpts.Context = global.Client.Context
+			clientset, namespace := global.mustConnect()
+			// The source is read with the same kubeconfig, only the context
+			// differs, and without --namespace from that context's namespace.
+			source := *global
+			source.Client.Context = args[0]
+			sourceClientset, sourceNamespace := source.mustConnect()
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), global.Timeout)
+			defer cancel()
+			dp, err := devpod.Import(ctx, sourceClientset, clientset, name, sourceNamespace, opts)
+			if err != nil {
+				fatal(ctx, err)
+			}
+			opts.Source = dp
+			if err := createDevpod(ctx, clientset, name, "deployment", namespace, opts, createOpts); err != nil {
+				fatal(ctx, err)
+			}
+		},
+	}
+
+	installCmd := &cobra.Command{
+		Use:   "install [--type job|cronjob|clusterrole]",
+		Short: "Set up the RBAC needed to run devpod from a job, a cronjob or everywhere with a clusterrole",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset, namespace := global.mustConnect()
+			ctx, cancel := context.WithTimeout(cmd.Context(), global.Timeout)
+			defer cancel()
+			install, err := devpod.NewInstallation(installType, namespace)
+			if err == nil {
+				err = devpod.Install(ctx, clientset, install, opts)
+			}
+			if err != nil {
+				fatal(ctx, err)
+			}
+			fmt.Fprintf(os.Stdout, "SUCCESS: Installed the devpod RBAC for --type %s\n", installType)
+		},
+	}
+
+	resumeCmd := &cobra.Command{
+		Use:               "resume [deployment/]{name}",
+		Short:             "Resume a devpod created with --paused",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeDeployments(global, true),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset, namespace := global.mustConnect()
+			ctx, cancel := context.WithTimeout(cmd.Context(), global.Timeout)
+			defer cancel()
+			_, name := parseResourceArg(args[0])
+			if err := devpod.Resume(ctx, clientset, devpod.Name(name), namespace); err != nil {
+				fatal(ctx, err)
+			}
+			fmt.Fprintf(os.Stdout, "SUCCESS: Resumed %s/%s\n", namespace, devpod.Name(name))
+		},
+	}
+
+	statusCmd := &cobra.Command{
+		Use:               "status [deployment/]{name}",
+		Short:             "Show the state of a devpod, its pods and its init scripts",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeDeployments(global, true),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset, namespace := global.mustConnect()
+			ctx, cancel := context.WithTimeout(cmd.Context(), global.Timeout)
+			defer cancel()
+			_, name := parseResourceArg(args[0])
+			showStatus(ctx, clientset, devpod.Name(name), namespace)
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the devpods in the namespace, or in every namespace with --all-namespaces",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if namespaceLabelSelector != "" && !allNamespaces {
+				fmt.Fprintf(os.Stderr, "ERROR: --set-namespace-label-selector picks the namespaces searched with --all-namespaces, it needs --all-namespaces\n")
+				os.Exit(1)
+			}
+			clientset, namespace := global.mustConnect()
+			ctx, cancel := context.WithTimeout(cmd.Context(), global.Timeout)
+			defer cancel()
+			showList(ctx, clientset, namespace, allNamespaces, namespaceLabelSelector)
+		},
+	}
+
+	topCmd := &cobra.Command{
+		Use:               "top [deployment/]{name}",
+		Short:             "Show the resource usage of the devpod containers until interrupted",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeDeployments(global, true),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset, namespace := global.mustConnect()
+			_, name := parseResourceArg(args[0])
+			showTop(cmd.Context(), clientset, &global.Client, devpod.Name(name), namespace, refreshInterval, global.Timeout)
+		},
+	}
+
+	root.AddCommand(createCmd, importCmd, installCmd, resumeCmd, statusCmd, listCmd, topCmd)
+
+	globalFlags := root.PersistentFlags()
+	globalFlags.StringVarP(&global.Namespace, "namespace", "n", "", "If present, the `namespace` scope for this CLI request")
+	globalFlags.StringVar(&global.Client.Kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file, KUBECONFIG will be used if absent")
+	globalFlags.StringVar(&global.Client.Context, "context", "", "the `name` of the kubeconfig context to use, the current context will be used if absent")
+	globalFlags.BoolVar(&global.Client.InCluster, "in-cluster", false, "use the service account of the pod devpod is running in instead of a kubeconfig")
+	globalFlags.StringVar(&global.Client.Proxy, "kube-api-proxy", "", "`url` of a proxy to send every Kubernetes API request through, e.g. an auditing proxy")
+	globalFlags.BoolVar(&global.UseContextNamespace, "kubecontext-namespace", true, "use the namespace of the kubeconfig context when --namespace is absent, otherwise \"default\" is used")
+	globalFlags.DurationVar(&global.Timeout, "timeout", 2*time.Minute, "how long the whole operation may take, including --wait, before giving up")
+	globalFlags.BoolVar(&global.Trace, "trace", false, "export OpenTelemetry spans of the image inspection and the API calls, the OTEL_EXPORTER_OTLP_* variables configure the collector")
+	globalFlags.StringVar(&global.OTelEndpoint, "otel-endpoint", "", "`url` of the OTLP/HTTP collector to export traces to, e.g. http://otel-collector:4318, implies --trace")
+	// The create flags are shared by the root and the create subcommand.
+	flags := pflag.NewFlagSet("create", pflag.ExitOnError)
+	flags.StringVarP(&labelSelector, "label-selector", "l", "", "create the devpod from the only deployment matching the label `selector` instead of a name")
+	flags.StringVar(&opts.FromFile, "from-file", "", "read the source deployment from a local YAML or JSON manifest `path` instead of the cluster, with --scripts-only or a --generate flag the cluster isn't needed at all")
+	flags.BoolVarP(&opts.Force, "force", "f", false, "remove an old devpod if it existed")
+	flags.StringVar(&opts.TargetNamespace, "namespace-target", "", "create the devpod in this `namespace` instead of the one of the source deployment, e.g. a personal scratch namespace, see --copy-secrets and --copy-configmaps")
+	flags.StringVar(&opts.SkopeoTransport, "skopeo-transport", "docker://", "set the transport to use when looking up remote container information")
+	flags.BoolVar(&opts.CreateOnly, "create-only", false, "fail if the devpod already exists instead of updating it")
+	flags.BoolVar(&opts.UpdateOnly, "update-only", false, "fail if the devpod doesn't exist yet instead of creating it")
+	flags.BoolVar(&opts.NoConfigMap, "no-configmap", false, "only make the devpod containers sleep, no images are inspected and no init script configmap is created")
+	flags.BoolVar(&opts.NoWaitForConfigMap, "no-wait-for-configmap", false, "create the devpod deployment without waiting for the init script configmap to be stored first, saves a round trip on slow clusters")
+	flags.IntVar(&opts.MaxPodCountBeforeWarn, "max-pod-count-before-warn", 50, "warn when the namespace already has more pods than this before creating the devpod, 0 disables the check")
+	flags.BoolVar(&opts.StripFinalizers, "strip-finalizers", true, "remove the finalizers copied from the source deployment")
+	flags.BoolVar(&opts.StripTopologySpread, "strip-pod-topology-spread", true, "remove the topology spread constraints copied from the source deployment")
+	flags.BoolVar(&opts.StripClusterAutoscalerAnnotations, "strip-cluster-autoscaler-annotations", false, "remove the cluster-autoscaler.kubernetes.io annotations from the devpod pods and mark them safe-to-evict so no node is kept or added for them")
+	flags.BoolVar(&opts.StripPodDisruptionBudget, "strip-pod-disruption-budget", false, "add a -devpod suffix to the pod labels a poddisruptionbudget of the namespace selects the devpod with, otherwise it's only a warning")
+	flags.BoolVar(&keepTopologySpread, "keep-pod-topology-spread", false, "keep the topology spread constraints copied from the source deployment, same as --strip-pod-topology-spread=false")
+	flags.BoolVar(&opts.KeepManagedFields, "keep-managed-fields", false, "keep the managedFields copied from the source deployment")
+	flags.StringVar(&opts.ServiceAccount, "service-account", "", "override the `name` of the service account the devpod runs as")
+	flags.BoolVar(&opts.CopyClusterRoles, "copy-cluster-roles", false, "bind the devpod service account to the clusterroles the source service account is bound to, used with --service-account")
+	flags.BoolVar(&opts.CopySecrets, "copy-secrets", false, "copy the secrets the source pods refer to and point the devpod at the copies, owner references are dropped")
+	flags.BoolVar(&opts.CopyConfigMaps, "copy-configmaps", false, "copy the configmaps the source pods refer to and point the devpod at the copies, owner references are dropped")
+	flags.BoolVar(&opts.CopyHPA, "copy-horizontal-pod-autoscaler", false, "copy the horizontalpodautoscaler of the source deployment so it scales the devpod instead, e.g. for load testing")
+	flags.BoolVar(&keepHPA, "keep-hpa", false, "keep the devpod autoscaled by copying the horizontalpodautoscaler of the source deployment, same as --copy-horizontal-pod-autoscaler, otherwise it's left out with a warning")
+	flags.Int32Var(&opts.HPAMinReplicas, "hpa-min-replicas", 0, "override the min replicas of the copied horizontalpodautoscaler, used with --copy-horizontal-pod-autoscaler")
+	flags.Int32Var(&opts.HPAMaxReplicas, "hpa-max-replicas", 0, "override the max replicas of the copied horizontalpodautoscaler, used with --copy-horizontal-pod-autoscaler")
+	flags.StringArrayVar(&opts.FieldRefEnv, "add-pod-env-from-field-ref", nil, "add a `NAME=fieldPath` Downward API environment variable to each devpod container, may be repeated")
+	flags.StringToStringVar(&opts.NodeSelector, "node-selector", nil, "merge a `key=value` pair into the devpod node selector, may be repeated")
+	flags.Int32Var(&opts.MaxHistoryLimit, "max-history-limit", 1, "number of old revisions of the devpod deployment to keep, 0 disables rollback history")
+	flags.StringVar(&opts.Strategy, "strategy", "Recreate", "the update strategy of the devpod deployment, Recreate or RollingUpdate")
+	flags.StringVar(&opts.MaxSurge, "max-surge", "", "the max surge of --strategy RollingUpdate, a number or a percentage like 25%")
+	flags.StringVar(&opts.MaxUnavailable, "max-unavailable", "", "the max unavailable of --strategy RollingUpdate, a number or a percentage like 25%")
+	flags.StringArrayVar(&opts.NodeAffinity, "node-affinity", nil, "add a `required|preferred,key=value` node affinity to the devpod, e.g. required,topology.kubernetes.io/zone=us-east-1a, may be repeated")
+	flags.StringArrayVar(&opts.Tolerations, "toleration", nil, "add a `key:operator:value:effect` toleration to the devpod, the key=value:effect form of kubectl taint works too, may be repeated")
+	flags.StringVar(&opts.RuntimeClass, "container-runtime-class", "", "`name` of the RuntimeClass the devpod pod runs with, e.g. kata-containers for VM isolation")
+	flags.DurationVar(&opts.ProgressDeadline, "progress-deadline", 10*time.Minute, "how long the devpod deployment may take to progress before it is considered failed")
+	flags.BoolVar(&opts.KeepProbes, "keep-probes", false, "keep the liveness, readiness and startup probes of the source containers")
+	flags.BoolVar(&opts.Paused, "paused", false, "create the devpod deployment paused so it can be edited before any pods start")
+	flags.BoolVar(&opts.NoInitContainers, "no-init-containers", false, "remove the init containers of the source from the devpod")
+	flags.StringVar(&opts.OverrideJSON, "override-json", "", "`json` merged into the devpod pod spec right before it's created, for anything the other flags don't cover")
+	flags.StringVar(&opts.PatchMetadataJSON, "patch-metadata-json", "", "JSON merge patch applied to only the devpod deployment metadata, e.g. '{\"labels\":{\"team\":\"backend\"}}'")
+	flags.StringVar(&opts.Patch, "patch", "", "JSON or YAML `patch` applied to the whole devpod deployment right before it's created, e.g. '{\"spec\":{\"template\":{\"spec\":{\"hostNetwork\":true}}}}'")
+	flags.StringVar(&opts.PatchType, "patch-type", devpod.PatchTypeMerge, "the type of --patch: merge, strategic or json (RFC 6902)")
+	flags.StringSliceVarP(&opts.Containers, "container", "c", nil, "`name` of a container to replace with sleep, all containers are replaced if absent, may be repeated or comma separated")
+	flags.StringSliceVar(&opts.Sidecars, "sidecar", nil, "`name` of a container to leave running as is instead of replacing it with sleep, e.g. a service mesh proxy, may be repeated")
+	flags.BoolVar(&opts.EnableDebugSidecar, "enable-debug-sidecar", false, "add a devpod-debug container running --debug-image with the volumes of the others mounted and the process namespace shared, like kubectl debug")
+	flags.StringVar(&opts.DebugImage, "debug-image", "nicolaka/netshoot", "`image` of the --enable-debug-sidecar container, it should have a shell and the debugging tools")
+	flags.StringVar(&opts.DevtoolsImage, "inject-devtools-image", "", "`image` of an init container whose /usr/local/bin is copied to /devtools in every devpod container, e.g. for gdb or strace")
+	flags.BoolVar(&opts.Privileged, "privileged", false, "run the devpod containers privileged")
+	flags.StringSliceVar(&opts.AddCaps, "add-cap", nil, "add a linux `capability` to the devpod containers, e.g. SYS_PTRACE, may be repeated")
+	flags.BoolVar(&opts.AddCapAll, "add-cap-all", false, "add the common debugging capabilities NET_ADMIN, SYS_PTRACE and SYS_ADMIN to the devpod containers")
+	flags.StringSliceVar(&opts.DropCaps, "drop-cap", nil, "drop a linux `capability` from the devpod containers, e.g. NET_RAW, may be repeated")
+	flags.BoolVar(&opts.RunAsRoot, "run-as-root", false, "run the devpod containers as root (uid 0)")
+	flags.BoolVar(&opts.StripResourceLimits, "strip-resource-limits", false, "remove the resource limits and requests of the devpod containers so the namespace LimitRange defaults apply, the --cpu/--memory flags are applied after")
+	flags.StringVar(&opts.CPULimit, "cpu-limit", "", "override the CPU limit of the devpod containers, e.g. 500m or 2")
+	flags.StringVar(&opts.MemoryLimit, "memory-limit", "", "override the memory limit of the devpod containers, e.g. 512Mi or 4Gi")
+	flags.StringVar(&opts.CPURequest, "cpu-request", "", "override the CPU request of the devpod containers")
+	flags.StringVar(&opts.MemoryRequest, "memory-request", "", "override the memory request of the devpod containers")
+	flags.StringToStringVar(&opts.AddResourceRequests, "add-resource-request", nil, "raise the requests of the devpod containers to at least the `name=quantity` pairs without changing the limits, e.g. cpu=100m,memory=128Mi, may be repeated")
+	flags.StringVar(&opts.GracefulShutdownSignal, "graceful-shutdown-hook", "", "trap the `signal` (e.g. SIGTERM) at the top of each init script so in-flight work can finish when the pod is replaced, the sleeping shell forwards it to everything started with kubectl exec and the grace period of the source is kept")
+	flags.StringVar(&opts.ClusterDomain, "cluster-domain", "cluster.local", "the DNS `domain` of the cluster, exported to the init scripts as DEVPOD_SERVICE_DOMAIN={namespace}.svc.{domain} for the service DNS names")
+	flags.StringVar(&opts.ImageTagOverride, "image-tag-override", "", "replace the `tag` of every container image of the source, init containers included, e.g. debug to run app:debug instead of app:1.2")
+	flags.StringToStringVar(&opts.ImageRegistryMirrors, "image-registry-mirror", nil, "pull and inspect the images of a `src=dst` registry from a mirror instead, e.g. docker.io=registry.internal, may be repeated")
+	flags.BoolVar(&opts.ImageDigestPin, "image-digest-pin", false, "pin the devpod container images to the digest their tag currently resolves to")
+	flags.StringVar(&opts.ImageDigestFile, "image-inspect-digest-file", "", "JSON file `path` used to cache resolved image digests and configs between runs")
+	flags.BoolVar(&opts.RefreshDigests, "refresh-digests", false, "ignore the cached entries in --image-inspect-digest-file and inspect every image again")
+	flags.StringVar(&opts.ImageOS, "os", "linux", "the OS, linux or windows, of the image inspected from a multi-platform manifest list")
+	flags.StringVar(&opts.ImageArch, "arch", "", "the architecture, amd64, arm64 or arm, of the image inspected from a multi-platform manifest list, the one devpod runs on if absent")
+	flags.StringVar(&opts.ImageVariant, "variant", "", "the ARM variant, v8, v7 or v6, of the image inspected from a multi-platform manifest list, e.g. v7 for a Raspberry Pi with --arch arm")
+	flags.StringVar(&opts.ImageRegistryToken, "image-inspect-registry-token", "", "bearer `token` sent to the registry when inspecting images, for registries with token auth only, takes precedence over the credentials in the auth file, e.g. ~/.docker/config.json")
+	flags.IntVar(&opts.ImageInspectParallelLimit, "image-inspect-parallel-limit", 4, "maximum number of images inspected at the same time")
+	flags.BoolVar(&createOpts.TTY, "tty", true, "include -t in the printed kubectl exec command")
+	flags.BoolVar(&createOpts.Stdin, "stdin", true, "include -i in the printed kubectl exec command")
+	flags.IntVar(&createOpts.TruncateCmdLength, "truncate-cmd-length", 0, "wrap the printed commands at this `column` with backslash continuations, 0 keeps them on one line")
+	flags.StringArrayVar(&createOpts.ExecFlags, "exec-flags", nil, "extra `flag` appended to the printed kubectl exec command, e.g. --exec-flags=--quiet, may be repeated")
+	flags.StringVar(&createOpts.SaveScripts, "save-scripts", "", "also write the generated init scripts to the `dir`ectory, e.g. for review")
+	flags.BoolVar(&createOpts.ScriptsOnly, "scripts-only", false, "only write the init scripts to --save-scripts, nothing is created in the cluster")
+	flags.BoolVar(&createOpts.ShowScripts, "show-scripts", false, "print the init scripts stored in the configmap of the devpod once it's created")
+	flags.BoolVar(&createOpts.Wait, "wait", false, "wait for the devpod pod to be running before exiting")
+	flags.BoolVar(&watchSource, "watch", false, "keep running and recreate the devpod, as with --force, every time the spec of the source deployment changes")
+	flags.BoolVar(&createOpts.Progress, "progress", false, "stream the events of the devpod pods to stderr while waiting, e.g. image pulls or scheduling failures, used with --wait")
+	flags.StringVar(&createOpts.OutputTemplate, "output-template", "", "print the created devpod deployment with a Go `template` to stdout instead of the usual message, e.g. '{{ .Name }} {{ .UID }}'")
+	flags.BoolVar(&createOpts.PrintPodName, "print-pod-name", false, "print only the name of the devpod pod to stdout once it's created, use with --wait to make sure it's running")
+	flags.BoolVarP(&opts.Verbose, "verbose", "v", false, "log the image and environment of every devpod container")
+	flags.BoolVar(&opts.SecretEnvMask, "secret-env-mask", true, "replace the values of environment variables named like *SECRET*, *PASSWORD*, *TOKEN* or *KEY* with *** in the --verbose output")
+	flags.BoolVar(&opts.CopySelectorLabels, "copy-label-selector-labels", true, "copy selector labels that are missing from the pod template labels")
+	flags.BoolVar(&opts.GenerateFluxKustomization, "generate-flux-kustomization", false, "print a Flux Kustomization for the devpod instead of creating it")
+	flags.StringVar(&opts.FluxSource, "flux-source", "flux-system", "name of the Flux GitRepository holding the devpod overlay, used with --generate-flux-kustomization")
+	flags.StringVar(&opts.FluxPath, "flux-path", "", "path to the devpod overlay in the Flux source, defaults to ./devpod/{namespace}/{name}-devpod")
+	flags.BoolVar(&opts.GenerateTelepresenceConfig, "generate-telepresence-config", false, "print a Telepresence intercept spec targeting the devpod instead of creating it")
+	flags.BoolVar(&opts.GenerateHelmValues, "generate-helm-values", false, "print Helm values that make a release of --helm-chart behave like the devpod instead of creating it")
+	flags.StringVar(&opts.HelmChart, "helm-chart", "", "`path` to the Helm chart the source deployment was installed from, used with --generate-helm-values")
+	flags.BoolVar(&opts.GenerateKubeconfig, "generate-kubeconfig-for-devpod", false, "create the devpod, then print a kubeconfig for its --service-account that can only reach pods in the namespace, e.g. to share the devpod")
+	flags.BoolVar(&opts.GenerateDevfile, "generate-devfile", false, "print a Devfile v2 for Eclipse Che / OpenShift Dev Spaces that starts like the devpod instead of creating it")
+	flags.BoolVar(&opts.GenerateKindConfig, "generate-kind-config", false, "print a kind cluster config with the devpod's hostPath volumes and ports mapped for reproducing it locally instead of creating it")
+	flags.BoolVar(&opts.GeneratePolicyException, "generate-policy-exception", false, "print a Kyverno PolicyException exempting the devpod from the library policies its changes break, e.g. require-pod-probes, instead of creating it")
+	flags.BoolVar(&opts.GenerateArgoRollout, "generate-argo-rollout", false, "print an Argo Rollout with the devpod spec and a simple canary strategy instead of creating it")
+	flags.BoolVar(&opts.GenerateOPABundle, "generate-open-policy-agent-bundle", false, "print OPA Gatekeeper ConstraintTemplates and constraints limiting who can create devpods, in the devpod's namespace and with at most its limits, instead of creating it")
+	flags.StringSliceVar(&opts.OPAAllowedUsers, "opa-allowed-user", nil, "`user` allowed to create devpods by --generate-open-policy-agent-bundle, may be repeated")
+	flags.StringSliceVar(&opts.OPAAllowedGroups, "opa-allowed-group", nil, "`group` allowed to create devpods by --generate-open-policy-agent-bundle, may be repeated, system:masters if no users or groups are given")
+	flags.BoolVar(&opts.GenerateConftestPolicy, "generate-conftest-policy", false, "write a conftest Rego policy checking the devpod manifests to policy/devpod.rego instead of creating it")
+	// nameTemplate := flags.String("name", "%s-devpod", "Set a name template to create the new resource")
+	root.Flags().AddFlagSet(flags)
+	createCmd.Flags().AddFlagSet(flags)
+	importCmd.Flags().AddFlagSet(flags)
+
+	installCmd.Flags().StringVar(&installType, "type", devpod.InstallJob, "what the install subcommand sets up RBAC for: job, cronjob or clusterrole")
+	listCmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "list the devpods in every namespace")
+	listCmd.Flags().StringVar(&namespaceLabelSelector, "set-namespace-label-selector", "", "only search the namespaces matching the label `selector` with --all-namespaces, e.g. team=backend")
+	topCmd.Flags().DurationVar(&refreshInterval, "refresh-interval", 2*time.Second, "how often the top subcommand refreshes the resource usage")
+
+	root.RegisterFlagCompletionFunc("namespace", completeNamespaces(global))
+	root.RegisterFlagCompletionFunc("container", completeContainers(global))
+	root.RegisterFlagCompletionFunc("sidecar", completeContainers(global))
+	installCmd.RegisterFlagCompletionFunc("type", cobra.FixedCompletions([]string{devpod.InstallJob, devpod.InstallCronJob, devpod.InstallClusterRole}, cobra.ShellCompDirectiveNoFileComp))
+
+	flagSets := []*pflag.FlagSet{globalFlags, flags, installCmd.Flags(), listCmd.Flags(), topCmd.Flags()}
+	if path, err := configPath(); err == nil {
+		config, err := loadConfig(path)
+		if err == nil {
+			err = applyConfig(config, flagSets...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to load config file %q: %s\n", path, err)
 			os.Exit(1)
 		}
-		dp.UID = ""
-		newDp = nil
-	} else {
-		fmt.Println(dp.UID)
-		fmt.Println(newDp.UID)
-		dp.UID = newDp.UID
 	}
-	dp.Name = newName
-	// Reset the resource version for new objects.
-	dp.ResourceVersion = ""
 
-	// Rename at least one key so this pod doesn't match the production version
-	keys := make([]string, 0, len(dp.Spec.Selector.MatchLabels))
-	for key := range dp.Spec.Selector.MatchLabels {
-		keys = append(keys, key)
+	envy.SetEnvNameOnFlagSet("kubeconfig", "KUBECONFIG", globalFlags)
+	for _, fs := range flagSets {
+		envy.ParseFlagSet("DEVPOD", fs)
 	}
 
-	sort.Strings(keys)
+	// A single context is passed to every API and registry call, so Ctrl-C
+	// cancels whatever is in flight instead of waiting for it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := root.ExecuteContext(ctx)
+	flushTracing()
+	if err != nil {
+		// cobra has already printed the error.
+		stop()
+		os.Exit(1)
+	}
+}
+
+// fatal prints err and exits, pointing at --timeout when the error is because
+// ctx ran out of time or at the interrupt when it was cancelled.
+func fatal(ctx context.Context, err error) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		fmt.Fprintf(os.Stderr, "ERROR: Timed out, use --timeout to allow more time: %s\n", err)
+	} else if errors.Is(ctx.Err(), context.Canceled) {
+		fmt.Fprintf(os.Stderr, "ERROR: Interrupted: %s\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+	}
+	// Exiting skips the deferred span ends, so end the current one here for it
+	// to be exported with the error.
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+	flushTracing()
+	os.Exit(1)
+}
 
-	// There must be at least one label selector
-	savedVal := dp.Spec.Selector.MatchLabels[keys[0]]
-	dp.Spec.Selector.MatchLabels[keys[0]] = fmt.Sprintf("%s-devpod", savedVal)
-	dp.Spec.Template.Labels[keys[0]] = fmt.Sprintf("%s-devpod", savedVal)
+// parseResourceArg splits a [resource/]{name} argument, the resource defaults to
+// "pod" when it's not given.
+func parseResourceArg(arg string) (string, string) {
+	resource := "pod"
+	name := arg
+	if strings.Contains(name, "/") {
+		splitList := strings.SplitN(name, "/", 2)
+		resource = strings.ToLower(splitList[0])
+		name = splitList[1]
+	}
+	return resource, name
+}
 
-	// Always move back to 1 replica
-	replicas := int32(1)
-	dp.Spec.Replicas = &replicas
+// createOptions controls what happens once the devpod has been applied.
+type createOptions struct {
+	Wait         bool
+	Progress     bool
+	PrintPodName bool
+
+	// OutputTemplate is a text/template executed with the created
+	// *appsv1.Deployment, e.g. {{ .Name }}.
+	OutputTemplate string
+
+	// TTY, Stdin and ExecFlags are used for the kubectl exec command that's
+	// printed once the devpod is created.
+	TTY       bool
+	Stdin     bool
+	ExecFlags []string
+
+	// TruncateCmdLength wraps the printed commands at this column, 0 keeps
+	// them on one line.
+	TruncateCmdLength int
+
+	// SaveScripts is a directory the init scripts are written to, with
+	// ScriptsOnly nothing else is done.
+	SaveScripts string
+	ScriptsOnly bool
+
+	// ShowScripts prints the init scripts of the created devpod.
+	ShowScripts bool
+
+	// Server is the API server put in the kubeconfig printed with
+	// --generate-kubeconfig-for-devpod.
+	Server string
+
+	// Kubeconfig and Context are the ones the devpod was created with, they
+	// are added to the printed commands so they reach the same cluster.
+	Kubeconfig string
+	Context    string
+}
 
-	if dp.Spec.Template.Labels == nil {
-		dp.Spec.Template.Labels = map[string]string{}
+// clientArgs returns the --kubeconfig and --context flags for the printed
+// commands, only the ones that were set are included.
+func clientArgs(createOpts *createOptions) []string {
+	args := []string{}
+	if createOpts.Kubeconfig != "" {
+		args = append(args, "--kubeconfig", strconv.Quote(createOpts.Kubeconfig))
 	}
-	if dp.Spec.Template.Annotations == nil {
-		dp.Spec.Template.Annotations = map[string]string{}
+	if createOpts.Context != "" {
+		args = append(args, "--context", strconv.Quote(createOpts.Context))
 	}
+	return args
+}
 
-	dp.Spec.Template.Labels["devpod"] = "devpod"
-	dp.Spec.Template.Annotations["devpod"] = "Created by devpod"
-	dp.Spec.Selector.MatchLabels["devpod"] = "devpod"
-	termGracePeriod := int64(1)
-	dp.Spec.Template.Spec.TerminationGracePeriodSeconds = &termGracePeriod
+// execHint returns the kubectl exec command that opens a shell in the devpod.
+func execHint(namespace, name string, createOpts *createOptions) string {
+	args := []string{"kubectl", "exec"}
+	switch {
+	case createOpts.Stdin && createOpts.TTY:
+		args = append(args, "-it")
+	case createOpts.Stdin:
+		args = append(args, "-i")
+	case createOpts.TTY:
+		args = append(args, "-t")
+	}
+	args = append(args, clientArgs(createOpts)...)
+	args = append(args, "-n", strconv.Quote(namespace))
+	args = append(args, createOpts.ExecFlags...)
+	args = append(args, strconv.Quote("deployment/"+name), "--", "sh")
+	return wrapCommand(args, createOpts.TruncateCmdLength)
+}
 
-	// dp.Spec.Template.Spec
-	cm := createInitContainer(&dp.Spec.Template.Spec, resource, namespace, name, skopeoTransport)
-	existingCm, err := clientset.CoreV1().ConfigMaps(cm.Namespace).Get(context.TODO(), cm.Name, metav1.GetOptions{})
-	if err != nil {
-		if !k8serr.IsNotFound(err) {
-			fmt.Fprintf(os.Stderr, "ERROR: Failed to check for configmap %q in namespace %q: %s\n", cm.Name, cm.Namespace, err)
-			os.Exit(1)
-		} else {
-			// Need to create
-			_, err := clientset.CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: Failed to create configmap %q in namespace %q: %s\n", cm.Name, cm.Namespace, err)
-				os.Exit(1)
-			}
+// wrapCommand joins args into a command indented by a space. With width above
+// 0 the line is broken with a backslash continuation before any arg that would
+// go past that column, the continuation lines are indented a bit more.
+func wrapCommand(args []string, width int) string {
+	lines := []string{}
+	line := " " + args[0]
+	for idx, arg := range args[1:] {
+		// Leave room for the " \" unless it's the last arg.
+		reserve := 2
+		if idx == len(args)-2 {
+			reserve = 0
 		}
-	} else {
-		// Need to update
-		cm.UID = existingCm.UID
-		_, err := clientset.CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+		if width > 0 && len(line)+1+len(arg)+reserve > width {
+			lines = append(lines, line+" \\")
+			line = "   " + arg
+			continue
+		}
+		line += " " + arg
+	}
+	return strings.Join(append(lines, line), "\n")
+}
+
+// watchDevpod recreates the devpod every time the spec of the source
+// deployment name changes until ctx is done, each recreation gets its own
+// timeout. A recreation that fails is reported and the next change is waited
+// for, so a transient API error doesn't end the watch.
+func watchDevpod(ctx context.Context, clientset kubernetes.Interface, name, namespace string, timeout time.Duration, opts *devpod.Options, createOpts *createOptions) {
+	// The devpod exists by now, so every change has to replace it.
+	opts.Force = true
+	fmt.Fprintf(os.Stderr, "Watching deployment %s/%s for changes, press Ctrl-C to stop...\n", namespace, name)
+	err := devpod.WatchSource(ctx, clientset, name, namespace, func(source *appsv1.Deployment) error {
+		fmt.Fprintf(os.Stderr, "Deployment %s/%s changed (generation %d), recreating the devpod...\n", namespace, name, source.Generation)
+		createCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := createDevpod(createCtx, clientset, name, "deployment", namespace, opts, createOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to recreate the devpod, still watching for changes: %s\n", err)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal(ctx, err)
+	}
+}
+
+// createDevpod builds the devpod for the resource and either prints the
+// requested manifests or applies it to the cluster.
+func createDevpod(ctx context.Context, clientset kubernetes.Interface, name, resource, namespace string, opts *devpod.Options, createOpts *createOptions) error {
+	// Parse the template first so a typo doesn't show up after the devpod
+	// has already been created.
+	var outputTemplate *template.Template
+	if createOpts.OutputTemplate != "" {
+		var err error
+		outputTemplate, err = template.New("output").Parse(createOpts.OutputTemplate)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Failed to update configmap %q in namespace %q: %s\n", cm.Name, cm.Namespace, err)
-			os.Exit(1)
+			return fmt.Errorf("invalid --output-template: %w", err)
 		}
 	}
 
-	var createdDp *appsv1.Deployment
-	var verb string
-	if newDp == nil {
-		verb = "create"
-		createdDp, err = clientset.AppsV1().Deployments(namespace).Create(context.TODO(), dp, metav1.CreateOptions{})
-	} else {
-		verb = "update"
-		createdDp, err = clientset.AppsV1().Deployments(namespace).Update(context.TODO(), dp, metav1.UpdateOptions{})
+	result, err := devpod.Build(ctx, clientset, name, resource, namespace, opts)
+	if err != nil {
+		return err
 	}
+	// The devpod is in a different namespace with --namespace-target.
+	namespace = result.Deployment.Namespace
+
+	if createOpts.SaveScripts != "" {
+		if err := devpod.SaveScripts(result.ConfigMap, createOpts.SaveScripts); err != nil {
+			return fmt.Errorf("failed to save the init scripts of devpod %q: %w", result.Deployment.Name, err)
+		}
+		if createOpts.ScriptsOnly {
+			fmt.Fprintf(os.Stdout, "SUCCESS: Saved the init scripts of %s/%s to %s\n", namespace, result.Deployment.Name, createOpts.SaveScripts)
+			return nil
+		}
+	}
+
+	manifests, err := devpod.GenerateManifests(result, opts)
 	if err != nil {
-		if force {
-			dp.UID = ""
-			fmt.Printf("Devpod %s/%s already exists, removing and re-creating since --force was set.\n", namespace, dp.Name)
-			err := clientset.AppsV1().Deployments(namespace).Delete(context.TODO(), dp.Name, metav1.DeleteOptions{})
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: Failed to delete and re-create devpod named %q in namespace %q: %s\n", dp.Name, namespace, err)
-				os.Exit(1)
+		return err
+	}
+	if len(manifests) > 0 {
+		for _, manifest := range manifests {
+			if file, ok := manifest.(*devpod.File); ok {
+				if err := writeFile(file); err != nil {
+					return fmt.Errorf("failed to write %q for devpod %q: %w", file.Path, result.Deployment.Name, err)
+				}
+				continue
 			}
-			createdDp, err = clientset.AppsV1().Deployments(namespace).Create(context.TODO(), dp, metav1.CreateOptions{})
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: Failed to re-create devpod named %q in namespace %q: %s\n", dp.Name, namespace, err)
-				os.Exit(1)
+			if err := printManifest(manifest); err != nil {
+				return fmt.Errorf("failed to print manifest for devpod %q: %w", result.Deployment.Name, err)
 			}
+		}
+		return nil
+	}
+
+	createdDp, err := devpod.Apply(ctx, clientset, result, opts)
+	if err != nil {
+		return err
+	}
+
+	// Only the pod name and the output template go to stdout when they're
+	// requested so they can be used by scripts.
+	out := os.Stdout
+	if createOpts.PrintPodName || outputTemplate != nil || opts.GenerateKubeconfig {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "SUCCESS: Created %s/%s, to access run:\n", namespace, createdDp.Name)
+	fmt.Fprintln(out, execHint(namespace, createdDp.Name, createOpts))
+	if opts.EnableDebugSidecar {
+		fmt.Fprintf(out, "Add -c %s to the command to debug from the sidecar.\n", devpod.DebugSidecarName)
+	}
+	if createdDp.Spec.Paused {
+		fmt.Fprintf(out, "The devpod is paused, no pods will start until you run:\n")
+		args := append([]string{os.Args[0], "resume"}, clientArgs(createOpts)...)
+		args = append(args, "-n", strconv.Quote(namespace), "deployment/"+strconv.Quote(createdDp.Name))
+		fmt.Fprintln(out, wrapCommand(args, createOpts.TruncateCmdLength))
+	}
+	if createOpts.ShowScripts {
+		// What's stored is printed rather than what was generated, so it
+		// shows what the devpod actually runs.
+		cm, err := clientset.CoreV1().ConfigMaps(result.ConfigMap.Namespace).Get(ctx, result.ConfigMap.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get the init scripts of devpod %q: %w", createdDp.Name, err)
+		}
+		printScripts(out, cm)
+	}
+
+	var pod *v1.Pod
+	if createOpts.Wait {
+		fmt.Fprintf(os.Stderr, "Waiting for devpod %s/%s to be running...\n", namespace, createdDp.Name)
+		if createOpts.Progress {
+			progressCtx, stopProgress := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				devpod.StreamEvents(progressCtx, clientset, createdDp, os.Stderr)
+				close(done)
+			}()
+			pod, err = devpod.WaitForPod(ctx, clientset, createdDp)
+			stopProgress()
+			<-done
 		} else {
-			fmt.Fprintf(os.Stderr, "ERROR: Failed to %s devpod %q in namespace %q: %s\n", verb, dp.Name, namespace, err)
-			fmt.Fprintf(os.Stderr, "You can use --force to delete it and re-create\n")
-			os.Exit(1)
+			pod, err = devpod.WaitForPod(ctx, clientset, createdDp)
+		}
+	} else if createOpts.PrintPodName {
+		pod, err = devpod.RunningPod(ctx, clientset, createdDp)
+		if err == nil && pod == nil {
+			err = fmt.Errorf("devpod %s/%s has no running pod yet, use --wait to wait for it", namespace, createdDp.Name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if outputTemplate != nil {
+		if err := outputTemplate.Execute(os.Stdout, createdDp); err != nil {
+			return fmt.Errorf("failed to execute --output-template for devpod %q: %w", createdDp.Name, err)
+		}
+		fmt.Fprintln(os.Stdout)
+	}
+	if createOpts.PrintPodName {
+		fmt.Fprintln(os.Stdout, pod.Name)
+	}
+	if opts.GenerateKubeconfig {
+		config, err := devpod.AccessKubeconfig(ctx, clientset, createdDp, createOpts.Server, opts)
+		if err != nil {
+			return err
+		}
+		data, err := clientcmd.Write(*config)
+		if err != nil {
+			return fmt.Errorf("failed to write kubeconfig for devpod %q: %w", createdDp.Name, err)
 		}
+		os.Stdout.Write(data)
 	}
-	fmt.Fprintf(os.Stdout, "SUCCESS: Created %s/%s, to access run:\n", namespace, createdDp.Name)
-	fmt.Fprintf(os.Stdout, " kubectl exec -it -n %q deployment/%q -- sh\n", namespace, createdDp.Name)
+	return nil
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fernferret/devpod/pkg/devpod"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxStatusEvents is the number of recent events shown for each devpod pod.
+const maxStatusEvents = 10
+
+// showStatus prints the state of the devpod deployment, its pods and the
+// generated init scripts.
+func showStatus(ctx context.Context, clientset kubernetes.Interface, name, namespace string) {
+	status, err := devpod.GetStatus(ctx, clientset, name, namespace)
+	if err != nil {
+		fatal(ctx, err)
+	}
+	dp := status.Deployment
+
+	fmt.Fprintf(os.Stdout, "Devpod:    %s/%s\n", namespace, dp.Name)
+	fmt.Fprintf(os.Stdout, "Ready:     %d/%d\n", dp.Status.ReadyReplicas, dp.Status.Replicas)
+	fmt.Fprintf(os.Stdout, "Paused:    %t\n", dp.Spec.Paused)
+
+	for _, podStatus := range status.Pods {
+		pod := podStatus.Pod
+		fmt.Fprintf(os.Stdout, "\nPod:       %s\n", pod.Name)
+		fmt.Fprintf(os.Stdout, "Phase:     %s\n", pod.Status.Phase)
+		if pod.Spec.NodeName != "" {
+			fmt.Fprintf(os.Stdout, "Node:      %s\n", pod.Spec.NodeName)
+		}
+		fmt.Fprintf(os.Stdout, "Containers:\n")
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			fmt.Fprintf(os.Stdout, "  %s: ready=%t restarts=%d %s\n", containerStatus.Name, containerStatus.Ready, containerStatus.RestartCount, containerState(containerStatus.State))
+		}
+		printEvents(podStatus.Events)
+	}
+
+	if status.ConfigMap == nil {
+		fmt.Fprintf(os.Stdout, "\nNo init scripts found, configmap %q does not exist\n", fmt.Sprintf("%s-init", dp.Name))
+		return
+	}
+	printScripts(os.Stdout, status.ConfigMap)
+}
+
+// containerState describes a container state in a single line.
+func containerState(state v1.ContainerState) string {
+	switch {
+	case state.Running != nil:
+		return fmt.Sprintf("running since %s", state.Running.StartedAt.Format("2006-01-02 15:04:05"))
+	case state.Waiting != nil:
+		return fmt.Sprintf("waiting: %s %s", state.Waiting.Reason, state.Waiting.Message)
+	case state.Terminated != nil:
+		return fmt.Sprintf("terminated: %s (exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+	}
+	return "unknown"
+}
+
+// printEvents prints the most recent events, the events must be sorted oldest
+// first.
+func printEvents(events []v1.Event) {
+	if len(events) == 0 {
+		return
+	}
+	if len(events) > maxStatusEvents {
+		events = events[len(events)-maxStatusEvents:]
+	}
+	fmt.Fprintf(os.Stdout, "Events:\n")
+	for _, event := range events {
+		fmt.Fprintf(os.Stdout, "  %s %s %s: %s\n", event.LastTimestamp.Format("15:04:05"), event.Type, event.Reason, event.Message)
+	}
+}
+
+// ANSI codes used by printScripts when writing to a terminal.
+const (
+	ansiBold  = "\033[1m"
+	ansiDim   = "\033[2m"
+	ansiReset = "\033[0m"
+)
+
+// isTerminal reports if out is a terminal rather than a pipe or a file.
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printScripts prints every init script in the ConfigMap in order to out. On a
+// terminal the headers are bold and the comments dimmed.
+func printScripts(out io.Writer, cm *v1.ConfigMap) {
+	filenames := make([]string, 0, len(cm.Data))
+	for filename := range cm.Data {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	color := isTerminal(out)
+	for _, filename := range filenames {
+		if !color {
+			fmt.Fprintf(out, "\n==> %s <==\n%s", filename, cm.Data[filename])
+			continue
+		}
+		fmt.Fprintf(out, "\n%s==> %s <==%s\n", ansiBold, filename, ansiReset)
+		for _, line := range strings.Split(strings.TrimSuffix(cm.Data[filename], "\n"), "\n") {
+			if strings.HasPrefix(line, "#") {
+				line = ansiDim + line + ansiReset
+			}
+			fmt.Fprintln(out, line)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fernferret/devpod/pkg/devpod"
+	"github.com/fernferret/devpod/pkg/k8s"
+	"k8s.io/client-go/kubernetes"
+	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+// showTop prints the resource usage of the devpod containers every interval,
+// formatted like kubectl top pods --containers, until it's interrupted. It runs
+// until ctx is done, so the timeout only applies to each refresh.
+func showTop(ctx context.Context, clientset kubernetes.Interface, clientOpts *k8s.ClientOptions, name, namespace string, interval, timeout time.Duration) {
+	config, err := clientOpts.RESTConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	metricsClient, err := metrics.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Failed to create metrics client: %s\n", err)
+		os.Exit(1)
+	}
+
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, timeout)
+		podMetrics, err := devpod.PodMetrics(refreshCtx, clientset, metricsClient, name, namespace)
+		if err != nil {
+			fatal(refreshCtx, err)
+		}
+		cancel()
+
+		fmt.Fprint(os.Stdout, clearScreen)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+		fmt.Fprintln(w, "POD\tNAME\tCPU(cores)\tMEMORY(bytes)")
+		for _, pod := range podMetrics {
+			for _, container := range pod.Containers {
+				cpu := container.Usage.Cpu().MilliValue()
+				memory := container.Usage.Memory().Value() / (1024 * 1024)
+				fmt.Fprintf(w, "%s\t%s\t%dm\t%dMi\n", pod.Name, container.Name, cpu, memory)
+			}
+		}
+		w.Flush()
+		if len(podMetrics) == 0 {
+			fmt.Fprintf(os.Stdout, "No metrics available for devpod %s/%s yet\n", namespace, name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// traceFlushTimeout limits how long exiting waits on the collector for the
+// last spans.
+const traceFlushTimeout = 5 * time.Second
+
+// tracerProvider is set once tracing is enabled so the spans can be flushed
+// before exiting, see flushTracing.
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing exports the spans to the OTLP/HTTP collector at endpoint, e.g.
+// http://otel-collector:4318 or otel-collector:4318 for https. Without an
+// endpoint the standard OTEL_EXPORTER_OTLP_* variables are used.
+func setupTracing(ctx context.Context, endpoint string) error {
+	var options []otlptracehttp.Option
+	if endpoint != "" {
+		parsed, err := url.Parse(endpoint)
+		if err != nil || parsed.Host == "" {
+			// Most likely a bare host:port without a scheme.
+			parsed = &url.URL{Scheme: "https", Host: endpoint}
+		}
+		options = append(options, otlptracehttp.WithEndpoint(parsed.Host))
+		if parsed.Scheme == "http" {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if parsed.Path != "" && parsed.Path != "/" {
+			options = append(options, otlptracehttp.WithURLPath(parsed.Path))
+		}
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return fmt.Errorf("unable to create the --otel-endpoint exporter: %w", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("devpod"))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// flushTracing exports the spans that haven't been yet, if tracing is enabled.
+func flushTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Failed to export the traces: %s\n", err)
+	}
+}
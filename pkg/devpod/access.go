@@ -0,0 +1,162 @@
+package devpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// errKubeconfigServiceAccount is returned when a kubeconfig is requested for a
+// devpod running as the service account of its source, handing out a token
+// for a production service account isn't what anyone wants.
+var errKubeconfigServiceAccount = errors.New("--service-account is required with --generate-kubeconfig-for-devpod")
+
+// accessRules let the holder of the kubeconfig find the devpod pod and exec,
+// port-forward and read logs. Pod names are random so pods can't be limited
+// by name, this covers every pod in the namespace.
+func accessRules(dp *appsv1.Deployment) []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups:     []string{"apps"},
+			Resources:     []string{"deployments"},
+			ResourceNames: []string{dp.Name},
+			Verbs:         []string{"get"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods/exec", "pods/portforward"},
+			Verbs:     []string{"create"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods/log"},
+			Verbs:     []string{"get"},
+		},
+	}
+}
+
+// ensureServiceAccount creates the service account the devpod dp runs as if it
+// doesn't exist yet, it reports if the service account is new.
+func ensureServiceAccount(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment) (bool, error) {
+	sa := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        dp.Spec.Template.Spec.ServiceAccountName,
+			Namespace:   dp.Namespace,
+			Labels:      map[string]string{"devpod": "devpod"},
+			Annotations: map[string]string{"devpod": "Created by devpod"},
+		},
+	}
+	_, err := clientset.CoreV1().ServiceAccounts(sa.Namespace).Create(ctx, sa, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to create service account %q in namespace %q: %w", sa.Name, sa.Namespace, err)
+	}
+	return true, nil
+}
+
+// AccessKubeconfig grants the service account of the devpod dp access to the
+// devpod and returns a kubeconfig that authenticates as it against server. A
+// long lived token Secret is created for the service account since the
+// kubeconfig is meant to be handed to someone else.
+func AccessKubeconfig(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment, server string, opts *Options) (*clientcmdapi.Config, error) {
+	if opts.ServiceAccount == "" {
+		return nil, errKubeconfigServiceAccount
+	}
+	namespace := dp.Namespace
+	saName := dp.Spec.Template.Spec.ServiceAccountName
+	labels := map[string]string{"devpod": "devpod"}
+	name := fmt.Sprintf("%s-access", dp.Name)
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Rules:      accessRules(dp),
+	}
+	roles := clientset.RbacV1().Roles(namespace)
+	_, err := roles.Create(ctx, role, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		_, err = roles.Update(ctx, role, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply role %q in namespace %q: %w", role.Name, namespace, err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      saName,
+			Namespace: namespace,
+		}},
+	}
+	bindings := clientset.RbacV1().RoleBindings(namespace)
+	_, err = bindings.Create(ctx, binding, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		_, err = bindings.Update(ctx, binding, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply rolebinding %q in namespace %q: %w", binding.Name, namespace, err)
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-token", name),
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{v1.ServiceAccountNameKey: saName},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+	_, err = clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil && !k8serr.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create token secret %q in namespace %q: %w", secret.Name, namespace, err)
+	}
+
+	// The token controller fills in the secret after it's created.
+	secretName := secret.Name
+	err = wait.PollImmediateUntilWithContext(ctx, waitInterval, func(ctx context.Context) (bool, error) {
+		secret, err = clientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("unable to get token secret %q in namespace %q: %w", secretName, namespace, err)
+		}
+		return len(secret.Data[v1.ServiceAccountTokenKey]) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("token secret %q in namespace %q never got a token: %w", secretName, namespace, err)
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters[dp.Name] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: secret.Data[v1.ServiceAccountRootCAKey],
+	}
+	config.AuthInfos[dp.Name] = &clientcmdapi.AuthInfo{
+		Token: string(secret.Data[v1.ServiceAccountTokenKey]),
+	}
+	config.Contexts[dp.Name] = &clientcmdapi.Context{
+		Cluster:   dp.Name,
+		AuthInfo:  dp.Name,
+		Namespace: namespace,
+	}
+	config.CurrentContext = dp.Name
+	return config, nil
+}
@@ -0,0 +1,148 @@
+package devpod
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fernferret/devpod/pkg/k8s"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// clusterKubeconfigEnv points at the kubeconfig of a real cluster to run the
+// cluster tests against, e.g. one started with envtest, kind or k3s. The tests
+// are skipped when it's empty. Every test gets a namespace of its own that's
+// deleted again afterwards.
+const clusterKubeconfigEnv = "DEVPOD_TEST_KUBECONFIG"
+
+// clusterNamespace connects to the cluster of clusterKubeconfigEnv and creates
+// a namespace for the test.
+func clusterNamespace(t *testing.T) (kubernetes.Interface, string) {
+	t.Helper()
+	kubeconfig := os.Getenv(clusterKubeconfigEnv)
+	if kubeconfig == "" {
+		t.Skipf("%s isn't set, skipping the test against a real cluster", clusterKubeconfigEnv)
+	}
+	clientOpts := &k8s.ClientOptions{Kubeconfig: kubeconfig}
+	clientset, err := clientOpts.Clientset()
+	if err != nil {
+		t.Fatalf("unable to connect to the cluster of %s: %v", clusterKubeconfigEnv, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ns, err := clientset.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "devpod-test-"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create a test namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := clientset.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{}); err != nil {
+			t.Errorf("unable to delete test namespace %q: %v", ns.Name, err)
+		}
+	})
+	return clientset, ns.Name
+}
+
+// clusterBuildAndApply builds the devpod of the api deployment in namespace
+// and applies it.
+func clusterBuildAndApply(t *testing.T, clientset kubernetes.Interface, namespace string, opts *Options) {
+	t.Helper()
+	// The API requires the deadline to be longer than minReadySeconds.
+	opts.ProgressDeadline = 10 * time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	devpod, err := Build(ctx, clientset, "api", "deployment", namespace, opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if _, err := Apply(ctx, clientset, devpod, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+}
+
+func TestClusterApplyIsIdempotent(t *testing.T) {
+	clientset, namespace := clusterNamespace(t)
+	ctx := context.Background()
+
+	source := sourceDeployment()
+	source.Namespace = namespace
+	source.UID = ""
+	// The API rejects a probe without a handler.
+	source.Spec.Template.Spec.Containers[0].ReadinessProbe = nil
+	if _, err := clientset.AppsV1().Deployments(namespace).Create(ctx, source, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unable to create the source deployment: %v", err)
+	}
+
+	clusterBuildAndApply(t, clientset, namespace, testOptions(t))
+	created, err := clientset.AppsV1().Deployments(namespace).Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("devpod deployment wasn't created: %v", err)
+	}
+
+	// Running devpod again without any change goes through the server side
+	// apply and must leave the spec alone.
+	clusterBuildAndApply(t, clientset, namespace, testOptions(t))
+	applied, err := clientset.AppsV1().Deployments(namespace).Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.UID != created.UID {
+		t.Errorf("devpod was replaced by the update, UID %q became %q", created.UID, applied.UID)
+	}
+	if applied.Generation != created.Generation {
+		t.Errorf("generation = %d after applying the same devpod again, want %d", applied.Generation, created.Generation)
+	}
+	applyManaged := false
+	for _, entry := range applied.ManagedFields {
+		applyManaged = applyManaged || (entry.Manager == fieldManager && entry.Operation == metav1.ManagedFieldsOperationApply)
+	}
+	if !applyManaged {
+		t.Errorf("no fields are owned by an apply of %q: %+v", fieldManager, applied.ManagedFields)
+	}
+
+	// A change of the source is applied in place.
+	current, err := clientset.AppsV1().Deployments(namespace).Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Spec.Template.Spec.Containers[0].Image = "registry.example.com/api:2.0"
+	current.Spec.Template.Spec.Containers[0].Args = []string{"--listen", ":9090"}
+	if _, err := clientset.AppsV1().Deployments(namespace).Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	clusterBuildAndApply(t, clientset, namespace, testOptions(t))
+	updated, err := clientset.AppsV1().Deployments(namespace).Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.UID != created.UID {
+		t.Errorf("devpod was replaced by the update, UID %q became %q", created.UID, updated.UID)
+	}
+	if updated.Generation <= applied.Generation {
+		t.Errorf("generation = %d after changing the source, want more than %d", updated.Generation, applied.Generation)
+	}
+	if got := updated.Spec.Template.Spec.Containers[0].Image; got != "registry.example.com/api:2.0" {
+		t.Errorf("image = %q after the update, want registry.example.com/api:2.0", got)
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "api-devpod-init", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastLine(cm.Data["0_app.sh"]); got != "/api --listen :9090" {
+		t.Errorf("init script runs %q after the update, want %q", got, "/api --listen :9090")
+	}
+
+	list, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("got %d deployments, want the source and its devpod", len(list.Items))
+	}
+}
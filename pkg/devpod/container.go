@@ -0,0 +1,540 @@
+package devpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fernferret/devpod/pkg/image"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// applyResources overrides the CPU and memory limits and requests of a devpod
+// container with the ones that were set, everything else is kept from the
+// source container unless StripResourceLimits is set.
+func applyResources(item *v1.Container, opts *Options) error {
+	// Production limits are usually too tight for debuggers like dlv or perf,
+	// without any the namespace LimitRange defaults apply instead.
+	if opts.StripResourceLimits {
+		item.Resources = v1.ResourceRequirements{}
+	}
+	overrides := []struct {
+		flag string
+		val  string
+		list *v1.ResourceList
+		name v1.ResourceName
+	}{
+		{"--cpu-limit", opts.CPULimit, &item.Resources.Limits, v1.ResourceCPU},
+		{"--memory-limit", opts.MemoryLimit, &item.Resources.Limits, v1.ResourceMemory},
+		{"--cpu-request", opts.CPURequest, &item.Resources.Requests, v1.ResourceCPU},
+		{"--memory-request", opts.MemoryRequest, &item.Resources.Requests, v1.ResourceMemory},
+	}
+	for _, override := range overrides {
+		if override.val == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(override.val)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", override.flag, override.val, err)
+		}
+		if *override.list == nil {
+			*override.list = v1.ResourceList{}
+		}
+		(*override.list)[override.name] = quantity
+	}
+	return addResourceRequests(item, opts.AddResourceRequests)
+}
+
+// addResourceRequests raises the requests of a devpod container to at least
+// the given quantities, a larger request of the source is kept and the limits
+// aren't changed.
+func addResourceRequests(item *v1.Container, requests map[string]string) error {
+	for name, val := range requests {
+		quantity, err := resource.ParseQuantity(val)
+		if err != nil {
+			return fmt.Errorf("invalid --add-resource-request %s=%q: %w", name, val, err)
+		}
+		resourceName := v1.ResourceName(name)
+		if current, ok := item.Resources.Requests[resourceName]; ok && current.Cmp(quantity) >= 0 {
+			continue
+		}
+		if limit, ok := item.Resources.Limits[resourceName]; ok && limit.Cmp(quantity) < 0 {
+			return fmt.Errorf("the --add-resource-request %s=%s of container %q is more than its limit %s", name, val, item.Name, limit.String())
+		}
+		if item.Resources.Requests == nil {
+			item.Resources.Requests = v1.ResourceList{}
+		}
+		item.Resources.Requests[resourceName] = quantity
+	}
+	return nil
+}
+
+// devtoolsVolume is the emptyDir the devtools init container fills in.
+const devtoolsVolume = "devpod-devtools"
+
+// injectDevtools prepends an init container running image that copies the
+// tools in its /usr/local/bin into a volume mounted at /devtools in every
+// container, so debuggers like gdb or strace are available in images that
+// don't ship them.
+func injectDevtools(pod *v1.PodSpec, image string) {
+	pod.Volumes = append(pod.Volumes, v1.Volume{
+		Name:         devtoolsVolume,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	initContainer := v1.Container{
+		Name:    devtoolsVolume,
+		Image:   image,
+		Command: []string{"sh", "-c", "cp -r /usr/local/bin/. /tools/"},
+		VolumeMounts: []v1.VolumeMount{{
+			Name:      devtoolsVolume,
+			MountPath: "/tools",
+		}},
+	}
+	pod.InitContainers = append([]v1.Container{initContainer}, pod.InitContainers...)
+	for idx := range pod.Containers {
+		pod.Containers[idx].VolumeMounts = append(pod.Containers[idx].VolumeMounts, v1.VolumeMount{
+			Name:      devtoolsVolume,
+			MountPath: "/devtools",
+		})
+	}
+}
+
+// debugCapabilities are added with AddCapAll, they're what debuggers, packet
+// captures and mounting usually need.
+var debugCapabilities = []string{"NET_ADMIN", "SYS_PTRACE", "SYS_ADMIN"}
+
+// capabilityName normalizes a capability, e.g. cap_sys_ptrace to SYS_PTRACE.
+func capabilityName(capability string) v1.Capability {
+	return v1.Capability(strings.TrimPrefix(strings.ToUpper(capability), "CAP_"))
+}
+
+// applySecurityContext escalates the privileges of a devpod container based on
+// Privileged, AddCaps, AddCapAll, DropCaps and RunAsRoot.
+func applySecurityContext(item *v1.Container, opts *Options) {
+	addCaps := opts.AddCaps
+	if opts.AddCapAll {
+		addCaps = append(append([]string{}, debugCapabilities...), addCaps...)
+	}
+	if !opts.Privileged && len(addCaps) == 0 && len(opts.DropCaps) == 0 && !opts.RunAsRoot {
+		return
+	}
+	if item.SecurityContext == nil {
+		item.SecurityContext = &v1.SecurityContext{}
+	}
+	sc := item.SecurityContext
+	if opts.Privileged {
+		privileged := true
+		sc.Privileged = &privileged
+		// Privilege escalation can't be disabled on a privileged container.
+		sc.AllowPrivilegeEscalation = nil
+	}
+	if len(addCaps) > 0 || len(opts.DropCaps) > 0 {
+		if sc.Capabilities == nil {
+			sc.Capabilities = &v1.Capabilities{}
+		}
+	}
+	for _, capability := range addCaps {
+		name := capabilityName(capability)
+		if !containsCapability(name, sc.Capabilities.Add) {
+			sc.Capabilities.Add = append(sc.Capabilities.Add, name)
+		}
+		// A capability dropped by name wins over an added one, unlike ALL.
+		sc.Capabilities.Drop = removeCapability(name, sc.Capabilities.Drop)
+	}
+	for _, capability := range opts.DropCaps {
+		name := capabilityName(capability)
+		if !containsCapability(name, sc.Capabilities.Drop) {
+			sc.Capabilities.Drop = append(sc.Capabilities.Drop, name)
+		}
+	}
+	if opts.RunAsRoot {
+		root := int64(0)
+		sc.RunAsUser = &root
+		// Explicitly false rather than nil so a runAsNonRoot on the pod's
+		// security context doesn't keep the container from starting.
+		runAsNonRoot := false
+		sc.RunAsNonRoot = &runAsNonRoot
+	}
+}
+
+// containsCapability reports if name is one of caps.
+func containsCapability(name v1.Capability, caps []v1.Capability) bool {
+	for _, item := range caps {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// removeCapability returns caps without name.
+func removeCapability(name v1.Capability, caps []v1.Capability) []v1.Capability {
+	kept := caps[:0]
+	for _, item := range caps {
+		if item != name {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// DebugSidecarName is the name of the container added with EnableDebugSidecar.
+const DebugSidecarName = "devpod-debug"
+
+// injectDebugSidecar adds a container running image next to the others that
+// mounts every one of their volumes, with the process namespace shared so the
+// processes of the other containers can be inspected and traced from it, like
+// kubectl debug does with an ephemeral container.
+func injectDebugSidecar(pod *v1.PodSpec, image string) error {
+	sidecar := v1.Container{
+		Name:    DebugSidecarName,
+		Image:   image,
+		Command: []string{"sleep", "infinity"},
+		Stdin:   true,
+		TTY:     true,
+		SecurityContext: &v1.SecurityContext{
+			Capabilities: &v1.Capabilities{Add: []v1.Capability{"SYS_PTRACE"}},
+		},
+	}
+	mounted := map[string]bool{}
+	for _, container := range pod.Containers {
+		if container.Name == DebugSidecarName {
+			return fmt.Errorf("--enable-debug-sidecar can't add container %q, there's one with that name already", DebugSidecarName)
+		}
+		// Two containers may mount different volumes at the same path, the
+		// first one wins.
+		for _, mount := range container.VolumeMounts {
+			if mounted[mount.MountPath] {
+				continue
+			}
+			mounted[mount.MountPath] = true
+			sidecar.VolumeMounts = append(sidecar.VolumeMounts, mount)
+		}
+	}
+	pod.Containers = append(pod.Containers, sidecar)
+	share := true
+	pod.ShareProcessNamespace = &share
+	return nil
+}
+
+// secretEnvName matches the names of environment variables whose values are
+// likely secrets.
+var secretEnvName = regexp.MustCompile(`(?i)SECRET|PASSWORD|TOKEN|KEY`)
+
+// envSource describes where the value of an environment variable comes from,
+// the value itself is masked when SecretEnvMask is set and the name looks like
+// a secret.
+func envSource(item v1.EnvVar, opts *Options) string {
+	from := item.ValueFrom
+	switch {
+	case from == nil:
+		if opts.SecretEnvMask && secretEnvName.MatchString(item.Name) {
+			return "=***"
+		}
+		return "=" + item.Value
+	case from.SecretKeyRef != nil:
+		return fmt.Sprintf(" from secret %s key %s", from.SecretKeyRef.Name, from.SecretKeyRef.Key)
+	case from.ConfigMapKeyRef != nil:
+		return fmt.Sprintf(" from configmap %s key %s", from.ConfigMapKeyRef.Name, from.ConfigMapKeyRef.Key)
+	case from.FieldRef != nil:
+		return fmt.Sprintf(" from field %s", from.FieldRef.FieldPath)
+	case from.ResourceFieldRef != nil:
+		return fmt.Sprintf(" from resource %s", from.ResourceFieldRef.Resource)
+	}
+	return ""
+}
+
+// logContainers logs the image and environment of every container of the
+// devpod pod.
+func logContainers(pod *v1.PodSpec, opts *Options) {
+	for _, item := range pod.Containers {
+		mode := "debug"
+		if !opts.IsDebugContainer(item.Name) {
+			mode = "running"
+		}
+		opts.logf("Container %q (%s): image %s\n", item.Name, mode, item.Image)
+		for _, envFrom := range item.EnvFrom {
+			switch {
+			case envFrom.SecretRef != nil:
+				opts.logf("  envFrom secret %s\n", envFrom.SecretRef.Name)
+			case envFrom.ConfigMapRef != nil:
+				opts.logf("  envFrom configmap %s\n", envFrom.ConfigMapRef.Name)
+			}
+		}
+		for _, env := range item.Env {
+			opts.logf("  env %s%s\n", env.Name, envSource(env, opts))
+		}
+	}
+}
+
+// inspectImages looks up the image of every debug container that isn't cached
+// already. The images are inspected in parallel, with at most
+// ImageInspectParallelLimit registry connections at a time. The results are in
+// the same order as containers, the entry is nil for any container that was
+// skipped or whose image couldn't be inspected. Images that can't be inspected
+// are only warned about, unless ImageDigestPin needs their digest.
+func inspectImages(ctx context.Context, containers []v1.Container, cache image.DigestCache, opts *Options) ([]*image.Info, error) {
+	limit := opts.ImageInspectParallelLimit
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	ctx, span := tracer.Start(ctx, "inspect images")
+	defer span.End()
+
+	inspectOpts := opts.inspectOptions()
+	results := make([]*image.Info, len(containers))
+	errs := make([]error, len(containers))
+	var wg sync.WaitGroup
+	for idx, item := range containers {
+		if !opts.IsDebugContainer(item.Name) {
+			continue
+		}
+		if cached, ok := cache[inspectOpts.CacheKey(item.Image)]; ok && !opts.RefreshDigests {
+			results[idx] = cached
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, imageName string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ctx, span := tracer.Start(ctx, "inspect image", trace.WithAttributes(attribute.String("image.name", imageName)))
+			name, err := image.TransportName(opts.SkopeoTransport, imageName)
+			if err == nil {
+				results[idx], err = image.Inspect(ctx, name, inspectOpts)
+			}
+			endSpan(span, err)
+			errs[idx] = err
+		}(idx, item.Image)
+	}
+	wg.Wait()
+
+	for idx, err := range errs {
+		if err == nil {
+			continue
+		}
+		item := containers[idx]
+		if opts.ImageDigestPin {
+			return nil, fmt.Errorf("failed to inspect image %q of container %q for --image-digest-pin: %w", item.Image, item.Name, err)
+		}
+		opts.logf("WARNING: Unable to inspect image %q of container %q, its init script won't include the image ENTRYPOINT and CMD: %s\n", item.Image, item.Name, err)
+	}
+	return results, nil
+}
+
+// mirrorImage returns the name of the image on its --image-registry-mirror, it's
+// returned as is without any mirrors.
+func mirrorImage(name string, opts *Options) (string, error) {
+	if len(opts.ImageRegistryMirrors) == 0 {
+		return name, nil
+	}
+	return image.Mirror(name, opts.ImageRegistryMirrors)
+}
+
+// initScriptsVolume is the volume of the init ConfigMap, it's mounted at
+// initScriptsDir in every debug container.
+const (
+	initScriptsVolume = "devpod-init"
+	initScriptsDir    = "/devpod"
+)
+
+// createInitContainer makes the debug containers of the pod spec of the source
+// resource name in namespace sleep and returns the ConfigMap with their init
+// scripts, it goes in the targetNamespace of the devpod.
+func createInitContainer(ctx context.Context, pod *v1.PodSpec, resource, namespace, name, targetNamespace string, opts *Options) (*v1.ConfigMap, error) {
+	cm := v1.ConfigMap{}
+	cm.Name = fmt.Sprintf("%s-devpod-init", name)
+	cm.Namespace = targetNamespace
+	cm.Data = map[string]string{}
+
+	// Init containers are left alone so anything they set up (shared volumes,
+	// migrations) is still there, unless they've been explicitly removed.
+	if opts.NoInitContainers {
+		pod.InitContainers = nil
+	}
+
+	for _, containerName := range opts.Containers {
+		found := false
+		for _, item := range pod.Containers {
+			found = found || item.Name == containerName
+		}
+		if !found {
+			return nil, fmt.Errorf("container %q does not exist in %s %s/%s", containerName, resource, namespace, name)
+		}
+	}
+
+	// Every image is pulled from the mirror, not only the debug ones, and
+	// inspected there too. The containers Build injects afterwards are
+	// mirrored when they're added.
+	for _, list := range [][]v1.Container{pod.InitContainers, pod.Containers} {
+		for idx, item := range list {
+			mirrored, err := mirrorImage(item.Image, opts)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --image-registry-mirror for image %q of container %q: %w", item.Image, item.Name, err)
+			}
+			list[idx].Image = mirrored
+		}
+	}
+
+	// Retag before inspecting so the init scripts use the entrypoint of the
+	// image that will actually run. Like the mirror, every container of the
+	// source is retagged, the ones Build injects keep their own images.
+	if opts.ImageTagOverride != "" {
+		for _, list := range [][]v1.Container{pod.InitContainers, pod.Containers} {
+			for idx, item := range list {
+				retagged, err := image.Retag(item.Image, opts.ImageTagOverride)
+				if err != nil {
+					return nil, fmt.Errorf("invalid --image-tag-override %q for image %q of container %q: %w", opts.ImageTagOverride, item.Image, item.Name, err)
+				}
+				list[idx].Image = retagged
+			}
+		}
+	}
+
+	switch opts.ImageOS {
+	case "", "linux", "windows":
+	default:
+		return nil, fmt.Errorf("invalid --os %q, must be linux or windows", opts.ImageOS)
+	}
+	switch opts.ImageArch {
+	case "", "amd64", "arm64", "arm":
+	default:
+		return nil, fmt.Errorf("invalid --arch %q, must be amd64, arm64 or arm", opts.ImageArch)
+	}
+	switch opts.ImageVariant {
+	case "", "v8", "v7", "v6":
+	default:
+		return nil, fmt.Errorf("invalid --variant %q, must be v8, v7 or v6", opts.ImageVariant)
+	}
+
+	cache, err := image.LoadDigestCache(opts.ImageDigestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image digest file %q: %w", opts.ImageDigestFile, err)
+	}
+
+	signal := ""
+	if opts.GracefulShutdownSignal != "" {
+		signal, err = trapSignal(opts.GracefulShutdownSignal)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if errs := validation.IsDNS1123Subdomain(opts.ClusterDomain); opts.ClusterDomain != "" && len(errs) > 0 {
+		return nil, fmt.Errorf("invalid --cluster-domain %q: %s", opts.ClusterDomain, strings.Join(errs, ", "))
+	}
+	serviceDomain := ""
+	if opts.ClusterDomain != "" {
+		serviceDomain = fmt.Sprintf("%s.svc.%s", targetNamespace, opts.ClusterDomain)
+	}
+
+	// Without the ConfigMap there are no init scripts to fill in, so the
+	// images don't need to be inspected either.
+	imageDetailsList := make([]*image.Info, len(pod.Containers))
+	if opts.NoConfigMap {
+		if opts.ImageDigestPin {
+			return nil, errors.New("--image-digest-pin needs the images to be inspected, it can't be used with --no-configmap")
+		}
+	} else {
+		imageDetailsList, err = inspectImages(ctx, pod.Containers, cache, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for idx, item := range pod.Containers {
+		if !opts.IsDebugContainer(item.Name) {
+			continue
+		}
+		imageDetails := imageDetailsList[idx]
+		if imageDetails != nil {
+			cache[opts.inspectOptions().CacheKey(item.Image)] = imageDetails
+		}
+		if opts.ImageDigestPin && imageDetails != nil {
+			pinned, err := image.Pin(item.Image, imageDetails.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to pin image %q for container %q: %w", item.Image, item.Name, err)
+			}
+			item.Image = pinned
+		}
+		scriptHint := ""
+		if !opts.NoConfigMap {
+			filename := initScriptName(idx, item.Name)
+			script, err := renderInitScript(item, imageDetails, signal, serviceDomain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate init script for container %q: %w", item.Name, err)
+			}
+			cm.Data[filename] = script
+			item.VolumeMounts = append(item.VolumeMounts, v1.VolumeMount{
+				Name:      initScriptsVolume,
+				MountPath: initScriptsDir,
+				ReadOnly:  true,
+			})
+			scriptHint = fmt.Sprintf("echo \"The original command was placed in %[1]s, run it with: %[1]s\"\n", path.Join(initScriptsDir, filename))
+		}
+
+		// Nothing is listening in a sleeping container, so any probes would
+		// just get the devpod restarted or keep it from becoming ready.
+		if !opts.KeepProbes {
+			item.LivenessProbe = nil
+			item.ReadinessProbe = nil
+			item.StartupProbe = nil
+		}
+
+		applySecurityContext(&item, opts)
+		if err := applyResources(&item, opts); err != nil {
+			return nil, err
+		}
+
+		// Surface the tail of the logs when the container dies without writing
+		// a termination message, which is almost always the case.
+		item.TerminationMessagePolicy = v1.TerminationMessageFallbackToLogsOnError
+
+		item.Command = []string{
+			"sh",
+			"-c",
+		}
+		sleep := "sleep infinity"
+		if signal != "" {
+			sleep = forwardSignalScript(signal)
+		}
+		item.Args = []string{
+			fmt.Sprintf(`echo "Welcome to DEVPOD"
+echo "This is a copy of the %s %s/%s"
+echo "All it does is just sleep forever and ever"
+echo ""
+%s
+%s`, resource, namespace, name, scriptHint, sleep),
+		}
+		pod.Containers[idx] = item
+	}
+
+	if err := image.SaveDigestCache(opts.ImageDigestFile, cache); err != nil {
+		return nil, fmt.Errorf("failed to save image digest file %q: %w", opts.ImageDigestFile, err)
+	}
+
+	if opts.NoConfigMap {
+		return nil, nil
+	}
+	// The scripts are executable so they can be run as is.
+	mode := int32(0o755)
+	pod.Volumes = append(pod.Volumes, v1.Volume{
+		Name: initScriptsVolume,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: cm.Name},
+				DefaultMode:          &mode,
+			},
+		},
+	})
+	return &cm, nil
+}
@@ -0,0 +1,36 @@
+package devpod
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fernferret/devpod/pkg/image"
+	v1 "k8s.io/api/core/v1"
+)
+
+// badImageContainers has an image that can't be parsed, so inspecting it fails
+// without reaching a registry.
+var badImageContainers = []v1.Container{{Name: "app", Image: "Not A Valid/Image"}}
+
+func TestInspectImagesWarns(t *testing.T) {
+	var log strings.Builder
+	opts := &Options{SkopeoTransport: "docker://", Log: &log}
+	results, err := inspectImages(context.Background(), badImageContainers, image.DigestCache{}, opts)
+	if err != nil {
+		t.Fatalf("inspectImages() error = %v", err)
+	}
+	if results[0] != nil {
+		t.Errorf("inspectImages() = %+v for an image that can't be inspected", results[0])
+	}
+	if !strings.Contains(log.String(), "WARNING") || !strings.Contains(log.String(), `"app"`) {
+		t.Errorf("no warning about container app was logged: %q", log.String())
+	}
+}
+
+func TestInspectImagesPinFails(t *testing.T) {
+	opts := &Options{SkopeoTransport: "docker://", ImageDigestPin: true}
+	if _, err := inspectImages(context.Background(), badImageContainers, image.DigestCache{}, opts); err == nil {
+		t.Error("inspectImages() didn't fail with --image-digest-pin for an image that can't be inspected")
+	}
+}
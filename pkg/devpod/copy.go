@@ -0,0 +1,210 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podContainers returns pointers to every container of the pod spec, init
+// containers included.
+func podContainers(pod *v1.PodSpec) []*v1.Container {
+	containers := []*v1.Container{}
+	for idx := range pod.InitContainers {
+		containers = append(containers, &pod.InitContainers[idx])
+	}
+	for idx := range pod.Containers {
+		containers = append(containers, &pod.Containers[idx])
+	}
+	return containers
+}
+
+// secretRefs returns pointers to the name of every Secret the pod spec refers
+// to from env, envFrom and volumes, so they can be pointed at a copy.
+func secretRefs(pod *v1.PodSpec) []*string {
+	refs := []*string{}
+	for _, container := range podContainers(pod) {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				refs = append(refs, &env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				refs = append(refs, &envFrom.SecretRef.Name)
+			}
+		}
+	}
+	for _, volume := range pod.Volumes {
+		if volume.Secret != nil {
+			refs = append(refs, &volume.Secret.SecretName)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					refs = append(refs, &source.Secret.Name)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// configMapRefs returns pointers to the name of every ConfigMap the pod spec
+// refers to from env, envFrom and volumes, so they can be pointed at a copy.
+func configMapRefs(pod *v1.PodSpec) []*string {
+	refs := []*string{}
+	for _, container := range podContainers(pod) {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+				refs = append(refs, &env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				refs = append(refs, &envFrom.ConfigMapRef.Name)
+			}
+		}
+	}
+	for _, volume := range pod.Volumes {
+		if volume.ConfigMap != nil {
+			refs = append(refs, &volume.ConfigMap.Name)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					refs = append(refs, &source.ConfigMap.Name)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// copyMeta returns the metadata for a devpod copy of an object, anything that
+// belongs to the original like its owners and resource version is dropped. The
+// suffix is always added, unlike Name, since a copy into the namespace of the
+// original would overwrite it otherwise.
+func copyMeta(meta metav1.ObjectMeta, namespace string) metav1.ObjectMeta {
+	labels := map[string]string{}
+	for key, val := range meta.Labels {
+		labels[key] = val
+	}
+	labels["devpod"] = "devpod"
+	return metav1.ObjectMeta{
+		Name:        fmt.Sprintf("%s-devpod", meta.Name),
+		Namespace:   namespace,
+		Labels:      labels,
+		Annotations: map[string]string{"devpod": "Created by devpod"},
+	}
+}
+
+// copySecrets copies every Secret the pod spec refers to from the source
+// namespace into the namespace of the devpod and points the spec at the
+// copies. Secrets that don't exist are left alone since they're optional, the
+// pod wouldn't start otherwise.
+func copySecrets(ctx context.Context, clientset kubernetes.Interface, sourceNamespace, namespace string, pod *v1.PodSpec, opts *Options) ([]*v1.Secret, error) {
+	copies := map[string]*v1.Secret{}
+	secrets := []*v1.Secret{}
+	for _, ref := range secretRefs(pod) {
+		if secret, ok := copies[*ref]; ok {
+			if secret != nil {
+				*ref = secret.Name
+			}
+			continue
+		}
+		source, err := clientset.CoreV1().Secrets(sourceNamespace).Get(ctx, *ref, metav1.GetOptions{})
+		if k8serr.IsNotFound(err) {
+			copies[*ref] = nil
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to get secret %q in namespace %q for --copy-secrets: %w", *ref, sourceNamespace, err)
+		}
+		// Token secrets are filled in by the cluster for the service account
+		// they belong to, a copy wouldn't get a token.
+		if source.Type == v1.SecretTypeServiceAccountToken {
+			opts.logf("WARNING: Not copying service account token secret %s/%s\n", sourceNamespace, source.Name)
+			copies[*ref] = nil
+			continue
+		}
+		secret := &v1.Secret{
+			ObjectMeta: copyMeta(source.ObjectMeta, namespace),
+			Type:       source.Type,
+			Immutable:  source.Immutable,
+			Data:       source.Data,
+		}
+		copies[*ref] = secret
+		secrets = append(secrets, secret)
+		*ref = secret.Name
+	}
+	return secrets, nil
+}
+
+// copyConfigMaps copies every ConfigMap the pod spec refers to from the source
+// namespace into the namespace of the devpod and points the spec at the
+// copies, the same way as copySecrets.
+func copyConfigMaps(ctx context.Context, clientset kubernetes.Interface, sourceNamespace, namespace string, pod *v1.PodSpec) ([]*v1.ConfigMap, error) {
+	copies := map[string]*v1.ConfigMap{}
+	configMaps := []*v1.ConfigMap{}
+	for _, ref := range configMapRefs(pod) {
+		if cm, ok := copies[*ref]; ok {
+			if cm != nil {
+				*ref = cm.Name
+			}
+			continue
+		}
+		source, err := clientset.CoreV1().ConfigMaps(sourceNamespace).Get(ctx, *ref, metav1.GetOptions{})
+		if k8serr.IsNotFound(err) {
+			copies[*ref] = nil
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to get configmap %q in namespace %q for --copy-configmaps: %w", *ref, sourceNamespace, err)
+		}
+		cm := &v1.ConfigMap{
+			ObjectMeta: copyMeta(source.ObjectMeta, namespace),
+			Immutable:  source.Immutable,
+			Data:       source.Data,
+			BinaryData: source.BinaryData,
+		}
+		copies[*ref] = cm
+		configMaps = append(configMaps, cm)
+		*ref = cm.Name
+	}
+	return configMaps, nil
+}
+
+// applyConfigMap creates the configmap or updates it if it already exists, it
+// reports if the configmap is new.
+func applyConfigMap(ctx context.Context, clientset kubernetes.Interface, cm *v1.ConfigMap) (bool, error) {
+	client := clientset.CoreV1().ConfigMaps(cm.Namespace)
+	created := true
+	_, err := client.Create(ctx, cm, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		created = false
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to apply configmap %q in namespace %q: %w", cm.Name, cm.Namespace, err)
+	}
+	return created, nil
+}
+
+// applySecret creates the secret or updates it if it already exists, it
+// reports if the secret is new.
+func applySecret(ctx context.Context, clientset kubernetes.Interface, secret *v1.Secret) (bool, error) {
+	client := clientset.CoreV1().Secrets(secret.Namespace)
+	created := true
+	_, err := client.Create(ctx, secret, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		created = false
+		_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to apply secret %q in namespace %q: %w", secret.Name, secret.Namespace, err)
+	}
+	return created, nil
+}
@@ -0,0 +1,62 @@
+package devpod
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCopySameNamespaceKeepsOriginals(t *testing.T) {
+	source := sourceDeployment()
+	source.Spec.Template.Spec.Containers[0].EnvFrom = []v1.EnvFromSource{
+		{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "creds-devpod"}}},
+		{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "settings-devpod"}}},
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds-devpod", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("original")},
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "settings-devpod", Namespace: "default"},
+		Data:       map[string]string{"mode": "original"},
+	}
+	clientset := newClientset(source, secret, cm)
+	opts := testOptions(t)
+	opts.CopySecrets = true
+	opts.CopyConfigMaps = true
+	buildAndApply(t, clientset, opts)
+
+	ctx := context.Background()
+	for _, name := range []string{"creds-devpod", "creds-devpod-devpod"} {
+		got, err := clientset.CoreV1().Secrets("default").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("secret %q is missing: %v", name, err)
+		}
+		if name == "creds-devpod" && got.Labels["devpod"] != "" {
+			t.Errorf("original secret %q was overwritten by its copy", name)
+		}
+	}
+	for _, name := range []string{"settings-devpod", "settings-devpod-devpod"} {
+		got, err := clientset.CoreV1().ConfigMaps("default").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("configmap %q is missing: %v", name, err)
+		}
+		if name == "settings-devpod" && got.Labels["devpod"] != "" {
+			t.Errorf("original configmap %q was overwritten by its copy", name)
+		}
+	}
+
+	dp, err := clientset.AppsV1().Deployments("default").Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envFrom := dp.Spec.Template.Spec.Containers[0].EnvFrom
+	if got := envFrom[0].SecretRef.Name; got != "creds-devpod-devpod" {
+		t.Errorf("devpod refers to secret %q, want the copy creds-devpod-devpod", got)
+	}
+	if got := envFrom[1].ConfigMapRef.Name; got != "settings-devpod-devpod" {
+		t.Errorf("devpod refers to configmap %q, want the copy settings-devpod-devpod", got)
+	}
+}
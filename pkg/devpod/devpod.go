@@ -0,0 +1,762 @@
+// Package devpod turns a running workload into a devpod: a single replica copy
+// of it where the containers sleep instead of running their command, so they
+// can be exec'd into and debugged.
+package devpod
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fernferret/devpod/pkg/k8s"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Name returns the name of the devpod created from the resource name, names
+// that already point at a devpod are returned as is.
+func Name(name string) string {
+	if strings.HasSuffix(name, "-devpod") {
+		return name
+	}
+	return fmt.Sprintf("%s-devpod", name)
+}
+
+// Devpod is a devpod that's been generated but not applied to the cluster yet.
+type Devpod struct {
+	Deployment *appsv1.Deployment
+
+	// ConfigMap holds the init scripts with the original commands of the
+	// containers.
+	ConfigMap *v1.ConfigMap
+
+	// Existing is the devpod deployment that's already in the cluster, it's
+	// nil if the devpod doesn't exist yet.
+	Existing *appsv1.Deployment
+
+	// ClusterRoleBindings are the copies made with --copy-cluster-roles.
+	ClusterRoleBindings []*rbacv1.ClusterRoleBinding
+
+	// Secrets are the copies made with --copy-secrets.
+	Secrets []*v1.Secret
+
+	// ConfigMaps are the copies made with --copy-configmaps.
+	ConfigMaps []*v1.ConfigMap
+
+	// HorizontalPodAutoscaler is the copy made with
+	// --copy-horizontal-pod-autoscaler, it's nil if there's nothing to copy.
+	HorizontalPodAutoscaler *autoscalingv2.HorizontalPodAutoscaler
+}
+
+// Build fetches the source deployment and generates the devpod from it, the
+// cluster isn't changed. With FromFile the source is read from the file
+// instead, clientset may then be nil to build the devpod without the cluster.
+func Build(ctx context.Context, clientset kubernetes.Interface, name, resource, namespace string, opts *Options) (*Devpod, error) {
+	// The source is read from namespace, everything the devpod needs is
+	// created in the target namespace.
+	targetNamespace := namespace
+	if opts.TargetNamespace != "" {
+		targetNamespace = opts.TargetNamespace
+	}
+
+	var dp *appsv1.Deployment
+	var err error
+	if opts.FromFile != "" {
+		if clientset == nil && (opts.CopySecrets || opts.CopyConfigMaps || opts.CopyClusterRoles || opts.CopyHPA) {
+			return nil, errors.New("--copy-secrets, --copy-configmaps, --copy-cluster-roles and --copy-horizontal-pod-autoscaler read from the cluster, they can't be used with --from-file without it")
+		}
+		dp, err = readDeployment(opts.FromFile, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from --from-file %q, cannot create devpod: %w", resource, opts.FromFile, err)
+		}
+		// The devpod goes in the namespace devpod was given, like it does
+		// for a deployment from the cluster.
+		name = dp.Name
+		dp.Namespace = targetNamespace
+	} else if opts.Source != nil {
+		if opts.CopySecrets || opts.CopyConfigMaps || opts.CopyClusterRoles || opts.CopyHPA {
+			return nil, errors.New("--copy-secrets, --copy-configmaps, --copy-cluster-roles and --copy-horizontal-pod-autoscaler read from the cluster of the devpod, they can't be used with a source from another cluster")
+		}
+		dp = opts.Source.DeepCopy()
+		name = dp.Name
+		dp.Namespace = targetNamespace
+	} else {
+		dp, err = clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to find %s %q in namespace %q, cannot create devpod: %w", resource, name, namespace, err)
+		}
+		dp.Namespace = targetNamespace
+	}
+
+	// A manifest from --from-file may not have a selector at all, and at least
+	// one of the match labels is renamed below.
+	if dp.Spec.Selector == nil || len(dp.Spec.Selector.MatchLabels) == 0 {
+		return nil, fmt.Errorf("%s %q has no spec.selector.matchLabels, cannot create devpod: at least one is needed so the devpod doesn't select the pods of the source", resource, dp.Name)
+	}
+
+	// Check for an existing devpod to at least get its UID
+	newName := fmt.Sprintf("%s-devpod", dp.Name)
+	var newDp *appsv1.Deployment
+	if clientset == nil {
+		dp.UID = ""
+	} else {
+		newDp, err = clientset.AppsV1().Deployments(targetNamespace).Get(ctx, newName, metav1.GetOptions{})
+		if err != nil {
+			if !k8serr.IsNotFound(err) {
+				return nil, fmt.Errorf("unable to search for %s %q in namespace %q, cannot create devpod: %w", resource, newName, targetNamespace, err)
+			}
+			if opts.UpdateOnly {
+				return nil, fmt.Errorf("devpod %q does not exist in namespace %q and --update-only was set", newName, targetNamespace)
+			}
+			dp.UID = ""
+			newDp = nil
+		} else {
+			if opts.CreateOnly {
+				return nil, fmt.Errorf("devpod %q already exists in namespace %q and --create-only was set", newName, targetNamespace)
+			}
+			dp.UID = newDp.UID
+		}
+	}
+	// Every devpod adds a pod, warn when the namespace is already busy enough
+	// to put pressure on the scheduler.
+	if opts.MaxPodCountBeforeWarn > 0 && clientset != nil {
+		pods, err := clientset.CoreV1().Pods(targetNamespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pods in namespace %q for --max-pod-count-before-warn: %w", targetNamespace, err)
+		}
+		if len(pods.Items) > opts.MaxPodCountBeforeWarn {
+			opts.logf("WARNING: Namespace %q already has %d pods, more than the %d of --max-pod-count-before-warn\n", targetNamespace, len(pods.Items), opts.MaxPodCountBeforeWarn)
+		}
+	}
+
+	dp.Name = newName
+	// Reset the resource version for new objects.
+	dp.ResourceVersion = ""
+
+	// Operator finalizers on the source would keep the devpod from being
+	// deleted, e.g. with --force.
+	if opts.StripFinalizers {
+		dp.Finalizers = nil
+	}
+
+	// The managed fields belong to whatever manages the source deployment.
+	if !opts.KeepManagedFields {
+		dp.ManagedFields = nil
+	}
+
+	if dp.Spec.Template.Labels == nil {
+		dp.Spec.Template.Labels = map[string]string{}
+	}
+
+	// A selector that doesn't match the template labels is rejected by the
+	// API, so fill in anything the source is missing.
+	if opts.CopySelectorLabels {
+		for key, val := range dp.Spec.Selector.MatchLabels {
+			if _, ok := dp.Spec.Template.Labels[key]; !ok {
+				dp.Spec.Template.Labels[key] = val
+			}
+		}
+	}
+
+	// Rename at least one key so this pod doesn't match the production version
+	keys := make([]string, 0, len(dp.Spec.Selector.MatchLabels))
+	for key := range dp.Spec.Selector.MatchLabels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	// There must be at least one label selector
+	savedVal := dp.Spec.Selector.MatchLabels[keys[0]]
+	dp.Spec.Selector.MatchLabels[keys[0]] = fmt.Sprintf("%s-devpod", savedVal)
+	dp.Spec.Template.Labels[keys[0]] = fmt.Sprintf("%s-devpod", savedVal)
+
+	// Always move back to 1 replica
+	replicas := int32(1)
+	dp.Spec.Replicas = &replicas
+
+	// A rolling update of a single replica only gets in the way, e.g. with a
+	// maxUnavailable of 0 the new devpod waits for the old one to go away.
+	dp.Spec.Strategy, err = deploymentStrategy(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Old revisions of a devpod are rarely useful, don't keep the source's
+	// history limit around.
+	historyLimit := opts.MaxHistoryLimit
+	dp.Spec.RevisionHistoryLimit = &historyLimit
+
+	// Large images can take a while to pull, so don't inherit a short deadline
+	// from the source.
+	progressDeadline := int32(opts.ProgressDeadline.Seconds())
+	dp.Spec.ProgressDeadlineSeconds = &progressDeadline
+
+	// Only pause the devpod when asked, even if the source is paused.
+	dp.Spec.Paused = opts.Paused
+
+	if dp.Spec.Template.Annotations == nil {
+		dp.Spec.Template.Annotations = map[string]string{}
+	}
+
+	dp.Spec.Template.Labels["devpod"] = "devpod"
+	dp.Spec.Template.Annotations["devpod"] = "Created by devpod"
+	dp.Spec.Selector.MatchLabels["devpod"] = "devpod"
+	// Annotations like safe-to-evict=false would make the Cluster Autoscaler
+	// keep a node (or add one) just for the devpod.
+	if opts.StripClusterAutoscalerAnnotations {
+		for key := range dp.Spec.Template.Annotations {
+			if strings.HasPrefix(key, clusterAutoscalerPrefix) {
+				delete(dp.Spec.Template.Annotations, key)
+			}
+		}
+		dp.Spec.Template.Annotations[clusterAutoscalerPrefix+"safe-to-evict"] = "true"
+	}
+	if clientset != nil {
+		if err := checkPodDisruptionBudgets(ctx, clientset, dp, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// The containers only sleep, so there's nothing to wait for when the pod
+	// is stopped. With --graceful-shutdown-hook the grace period of the source
+	// is kept, the kubelet kills the containers once it's over and the init
+	// scripts need the time to finish.
+	if opts.GracefulShutdownSignal == "" {
+		termGracePeriod := int64(1)
+		dp.Spec.Template.Spec.TerminationGracePeriodSeconds = &termGracePeriod
+	}
+
+	sourceServiceAccount := dp.Spec.Template.Spec.ServiceAccountName
+	if opts.ServiceAccount != "" {
+		dp.Spec.Template.Spec.ServiceAccountName = opts.ServiceAccount
+		dp.Spec.Template.Spec.DeprecatedServiceAccount = opts.ServiceAccount
+		// Let the new service account decide if its token is mounted.
+		dp.Spec.Template.Spec.AutomountServiceAccountToken = nil
+	}
+
+	// Every namespace has a default service account but any other one of the
+	// source may not exist in the target namespace, the pods would fail to be
+	// created. It's created with --generate-kubeconfig-for-devpod.
+	if serviceAccount := serviceAccountName(dp.Spec.Template.Spec.ServiceAccountName); targetNamespace != namespace && clientset != nil &&
+		serviceAccount != "default" && !opts.GenerateKubeconfig {
+		_, err := clientset.CoreV1().ServiceAccounts(targetNamespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+		if k8serr.IsNotFound(err) {
+			opts.logf("WARNING: Service account %q doesn't exist in namespace %q, the devpod pods can't start without it, see --service-account\n", serviceAccount, targetNamespace)
+		}
+	}
+
+	// Zone spread constraints can keep a single replica from scheduling when
+	// the zones don't have the same number of nodes.
+	if opts.StripTopologySpread {
+		dp.Spec.Template.Spec.TopologySpreadConstraints = nil
+	}
+
+	if len(opts.NodeSelector) > 0 {
+		if dp.Spec.Template.Spec.NodeSelector == nil {
+			dp.Spec.Template.Spec.NodeSelector = map[string]string{}
+		}
+		for key, val := range opts.NodeSelector {
+			dp.Spec.Template.Spec.NodeSelector[key] = val
+		}
+	}
+
+	for _, val := range opts.NodeAffinity {
+		required, reqs, err := k8s.ParseNodeAffinity(val)
+		if err != nil {
+			return nil, err
+		}
+		addNodeAffinity(&dp.Spec.Template.Spec, required, reqs)
+	}
+
+	for _, val := range opts.Tolerations {
+		toleration, err := k8s.ParseToleration(val)
+		if err != nil {
+			return nil, err
+		}
+		dp.Spec.Template.Spec.Tolerations = append(dp.Spec.Template.Spec.Tolerations, toleration)
+	}
+
+	if opts.RuntimeClass != "" {
+		// A missing RuntimeClass only shows up as a pod that's never created,
+		// so check for it up front when the cluster is available.
+		if clientset != nil {
+			if _, err := clientset.NodeV1().RuntimeClasses().Get(ctx, opts.RuntimeClass, metav1.GetOptions{}); err != nil {
+				return nil, fmt.Errorf("unable to find --container-runtime-class %q: %w", opts.RuntimeClass, err)
+			}
+		}
+		runtimeClass := opts.RuntimeClass
+		dp.Spec.Template.Spec.RuntimeClassName = &runtimeClass
+	}
+
+	fieldRefEnv, err := k8s.ParseFieldRefEnv(opts.FieldRefEnv)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range dp.Spec.Template.Spec.Containers {
+		container := &dp.Spec.Template.Spec.Containers[idx]
+		container.Env = append(container.Env, fieldRefEnv...)
+	}
+
+	var secrets []*v1.Secret
+	if opts.CopySecrets {
+		secrets, err = copySecrets(ctx, clientset, namespace, dp.Namespace, &dp.Spec.Template.Spec, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var configMaps []*v1.ConfigMap
+	if opts.CopyConfigMaps {
+		configMaps, err = copyConfigMaps(ctx, clientset, namespace, dp.Namespace, &dp.Spec.Template.Spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Pods can only use the secrets and configmaps of their own namespace.
+	if dp.Namespace != namespace {
+		if !opts.CopySecrets && len(secretRefs(&dp.Spec.Template.Spec)) > 0 {
+			opts.logf("WARNING: The devpod uses secrets of namespace %q, use --copy-secrets to copy them to namespace %q\n", namespace, dp.Namespace)
+		}
+		if !opts.CopyConfigMaps && len(configMapRefs(&dp.Spec.Template.Spec)) > 0 {
+			opts.logf("WARNING: The devpod uses configmaps of namespace %q, use --copy-configmaps to copy them to namespace %q\n", namespace, dp.Namespace)
+		}
+	}
+
+	// dp.Spec.Template.Spec
+	cm, err := createInitContainer(ctx, &dp.Spec.Template.Spec, resource, namespace, name, dp.Namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Before the devtools so the sidecar gets them too.
+	if opts.EnableDebugSidecar {
+		debugImage, err := mirrorImage(opts.DebugImage, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --image-registry-mirror for --debug-image %q: %w", opts.DebugImage, err)
+		}
+		if err := injectDebugSidecar(&dp.Spec.Template.Spec, debugImage); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DevtoolsImage != "" {
+		devtoolsImage, err := mirrorImage(opts.DevtoolsImage, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --image-registry-mirror for --inject-devtools-image %q: %w", opts.DevtoolsImage, err)
+		}
+		injectDevtools(&dp.Spec.Template.Spec, devtoolsImage)
+	}
+
+	// Unmarshal on top of the existing spec, objects are merged and lists are
+	// replaced.
+	if opts.OverrideJSON != "" {
+		if err := json.Unmarshal([]byte(opts.OverrideJSON), &dp.Spec.Template.Spec); err != nil {
+			return nil, fmt.Errorf("failed to apply --override-json to devpod %q: %w", dp.Name, err)
+		}
+	}
+
+	if opts.PatchMetadataJSON != "" {
+		if err := patchMetadata(dp, opts.PatchMetadataJSON); err != nil {
+			return nil, err
+		}
+	}
+
+	// The patch goes last so it sees the devpod exactly as it would be sent.
+	if opts.Patch != "" {
+		dp, err = patchDeployment(dp, opts.Patch, opts.PatchType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var bindings []*rbacv1.ClusterRoleBinding
+	if opts.CopyClusterRoles {
+		bindings, err = copyClusterRoleBindings(ctx, clientset, sourceServiceAccount, namespace, dp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var hpa *autoscalingv2.HorizontalPodAutoscaler
+	if opts.CopyHPA {
+		hpa, err = copyHorizontalPodAutoscaler(ctx, clientset, name, namespace, dp, opts)
+		if err != nil {
+			return nil, err
+		}
+	} else if clientset != nil && opts.Source == nil {
+		// An imported source is autoscaled in its own cluster, if at all.
+		warnHorizontalPodAutoscaler(ctx, clientset, name, namespace, opts)
+	}
+
+	if opts.Verbose {
+		logContainers(&dp.Spec.Template.Spec, opts)
+	}
+
+	return &Devpod{
+		Deployment:              dp,
+		ConfigMap:               cm,
+		Existing:                newDp,
+		ClusterRoleBindings:     bindings,
+		Secrets:                 secrets,
+		ConfigMaps:              configMaps,
+		HorizontalPodAutoscaler: hpa,
+	}, nil
+}
+
+// addNodeAffinity adds the node requirements to the affinity of the pod spec.
+// Required terms are ORed by the scheduler, so the requirements are added to
+// every existing term to keep the result narrower than the source, preferred
+// requirements get a term of their own.
+func addNodeAffinity(pod *v1.PodSpec, required bool, reqs []v1.NodeSelectorRequirement) {
+	if pod.Affinity == nil {
+		pod.Affinity = &v1.Affinity{}
+	}
+	if pod.Affinity.NodeAffinity == nil {
+		pod.Affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	affinity := pod.Affinity.NodeAffinity
+	if !required {
+		affinity.PreferredDuringSchedulingIgnoredDuringExecution = append(affinity.PreferredDuringSchedulingIgnoredDuringExecution, v1.PreferredSchedulingTerm{
+			Weight:     100,
+			Preference: v1.NodeSelectorTerm{MatchExpressions: reqs},
+		})
+		return
+	}
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{}
+	}
+	selector := affinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []v1.NodeSelectorTerm{{}}
+	}
+	for idx := range selector.NodeSelectorTerms {
+		term := &selector.NodeSelectorTerms[idx]
+		term.MatchExpressions = append(term.MatchExpressions, reqs...)
+	}
+}
+
+// clusterAutoscalerPrefix is the prefix of the annotations the Cluster
+// Autoscaler reads from pods.
+const clusterAutoscalerPrefix = "cluster-autoscaler.kubernetes.io/"
+
+// cleanupTimeout is how long the objects created by an interrupted Apply may
+// take to be deleted.
+const cleanupTimeout = 30 * time.Second
+
+// deploymentStrategy builds the update strategy of the devpod, Recreate unless
+// Strategy is RollingUpdate.
+func deploymentStrategy(opts *Options) (appsv1.DeploymentStrategy, error) {
+	switch appsv1.DeploymentStrategyType(opts.Strategy) {
+	case "", appsv1.RecreateDeploymentStrategyType:
+		if opts.MaxSurge != "" || opts.MaxUnavailable != "" {
+			return appsv1.DeploymentStrategy{}, fmt.Errorf("--max-surge and --max-unavailable need --strategy %s", appsv1.RollingUpdateDeploymentStrategyType)
+		}
+		return appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}, nil
+	case appsv1.RollingUpdateDeploymentStrategyType:
+		rolling := &appsv1.RollingUpdateDeployment{}
+		if opts.MaxSurge != "" {
+			maxSurge := intstr.Parse(opts.MaxSurge)
+			rolling.MaxSurge = &maxSurge
+		}
+		if opts.MaxUnavailable != "" {
+			maxUnavailable := intstr.Parse(opts.MaxUnavailable)
+			rolling.MaxUnavailable = &maxUnavailable
+		}
+		return appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType, RollingUpdate: rolling}, nil
+	}
+	return appsv1.DeploymentStrategy{}, fmt.Errorf("unknown --strategy %q, must be %s or %s", opts.Strategy, appsv1.RecreateDeploymentStrategyType, appsv1.RollingUpdateDeploymentStrategyType)
+}
+
+// Apply creates or updates the devpod ConfigMap and Deployment in the cluster
+// and returns the Deployment that was stored. If ctx is cancelled part way
+// through, e.g. by Ctrl-C, the objects it created are deleted again so no
+// orphans are left behind, anything that was only updated is left as is.
+func Apply(ctx context.Context, clientset kubernetes.Interface, devpod *Devpod, opts *Options) (_ *appsv1.Deployment, retErr error) {
+	dp := devpod.Deployment
+	cm := devpod.ConfigMap
+	namespace := dp.Namespace
+
+	var created []func(context.Context) error
+	defer func() {
+		if retErr == nil || ctx.Err() == nil || len(created) == 0 {
+			return
+		}
+		opts.logf("Interrupted, removing what was created for devpod %s/%s.\n", namespace, dp.Name)
+		// ctx is already done, so the deletes need one of their own.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		for idx := len(created) - 1; idx >= 0; idx-- {
+			if err := created[idx](cleanupCtx); err != nil && !k8serr.IsNotFound(err) {
+				opts.logf("WARNING: Cleanup failed: %s\n", err)
+			}
+		}
+	}()
+
+	// Nothing needs the init ConfigMap to exist when the Deployment is
+	// created, so with --no-wait-for-configmap the round trip isn't waited on
+	// until the end.
+	cmErr := make(chan error, 1)
+	cmCreated := false
+	go func() {
+		// With --no-configmap there's nothing to create.
+		if cm == nil {
+			cmErr <- nil
+			return
+		}
+		ctx, span := tracer.Start(ctx, "apply configmap", trace.WithAttributes(attribute.String("configmap.name", cm.Name)))
+		err := withRetry(ctx, func() error {
+			var err error
+			cmCreated, err = applyInitConfigMap(ctx, clientset, cm)
+			return err
+		})
+		endSpan(span, err)
+		cmErr <- err
+	}()
+	cmJoined := false
+	waitForConfigMap := func() error {
+		cmJoined = true
+		err := <-cmErr
+		if cmCreated {
+			created = append(created, func(ctx context.Context) error {
+				return clientset.CoreV1().ConfigMaps(cm.Namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{})
+			})
+		}
+		return err
+	}
+	defer func() {
+		// Join before the cleanup above runs so it knows about the ConfigMap.
+		if !cmJoined {
+			waitForConfigMap()
+		}
+	}()
+	if !opts.NoWaitForConfigMap {
+		if err := waitForConfigMap(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, secret := range devpod.Secrets {
+		var isNew bool
+		err := withRetry(ctx, func() (err error) {
+			isNew, err = applySecret(ctx, clientset, secret)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			secret := secret
+			created = append(created, func(ctx context.Context) error {
+				return clientset.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+			})
+		}
+		opts.logf("Copied secret %s/%s.\n", secret.Namespace, secret.Name)
+	}
+
+	for _, copied := range devpod.ConfigMaps {
+		var isNew bool
+		err := withRetry(ctx, func() (err error) {
+			isNew, err = applyConfigMap(ctx, clientset, copied)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			copied := copied
+			created = append(created, func(ctx context.Context) error {
+				return clientset.CoreV1().ConfigMaps(copied.Namespace).Delete(ctx, copied.Name, metav1.DeleteOptions{})
+			})
+		}
+		opts.logf("Copied configmap %s/%s.\n", copied.Namespace, copied.Name)
+	}
+
+	for _, binding := range devpod.ClusterRoleBindings {
+		var isNew bool
+		err := withRetry(ctx, func() (err error) {
+			isNew, err = applyClusterRoleBinding(ctx, clientset, binding)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			name := binding.Name
+			created = append(created, func(ctx context.Context) error {
+				return clientset.RbacV1().ClusterRoleBindings().Delete(ctx, name, metav1.DeleteOptions{})
+			})
+		}
+		opts.logf("Bound service account %q to clusterrole %q with %q.\n", binding.Subjects[0].Name, binding.RoleRef.Name, binding.Name)
+	}
+
+	// The pods can't be created until the service account exists.
+	if opts.GenerateKubeconfig {
+		if opts.ServiceAccount == "" {
+			return nil, errKubeconfigServiceAccount
+		}
+		var isNew bool
+		err := withRetry(ctx, func() (err error) {
+			isNew, err = ensureServiceAccount(ctx, clientset, dp)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			created = append(created, func(ctx context.Context) error {
+				return clientset.CoreV1().ServiceAccounts(namespace).Delete(ctx, dp.Spec.Template.Spec.ServiceAccountName, metav1.DeleteOptions{})
+			})
+			opts.logf("Created service account %s/%s.\n", namespace, dp.Spec.Template.Spec.ServiceAccountName)
+		}
+	}
+
+	dpCtx, span := tracer.Start(ctx, "apply deployment", trace.WithAttributes(attribute.String("deployment.name", dp.Name)))
+	createdDp, err := applyDeployment(dpCtx, clientset, dp, devpod.Existing != nil, opts, &created)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// The autoscaler goes last so it doesn't scale a deployment that's about
+	// to be replaced.
+	if hpa := devpod.HorizontalPodAutoscaler; hpa != nil {
+		err := withRetry(ctx, func() error {
+			return applyHorizontalPodAutoscaler(ctx, clientset, hpa)
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts.logf("Applied horizontalpodautoscaler %s/%s.\n", hpa.Namespace, hpa.Name)
+	}
+
+	if !cmJoined {
+		if err := waitForConfigMap(); err != nil {
+			return nil, err
+		}
+	}
+	return createdDp, nil
+}
+
+// applyDeployment creates the devpod deployment dp, or updates it if it
+// exists, replacing it with --force when that fails. Every deployment that's
+// created is added to created so it can be cleaned up.
+func applyDeployment(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment, exists bool, opts *Options, created *[]func(context.Context) error) (*appsv1.Deployment, error) {
+	namespace := dp.Namespace
+	var createdDp *appsv1.Deployment
+	deleteDp := func(ctx context.Context) error {
+		return clientset.AppsV1().Deployments(namespace).Delete(ctx, dp.Name, metav1.DeleteOptions{})
+	}
+	// A create that timed out may have gone through anyway, so when it's
+	// retried the devpod can already exist. That's the one it created, there's
+	// no need to fail or to replace it with --force.
+	createDp := func() error {
+		retried := false
+		return withRetry(ctx, func() (err error) {
+			createdDp, err = clientset.AppsV1().Deployments(namespace).Create(ctx, dp, metav1.CreateOptions{FieldManager: fieldManager})
+			if retried && k8serr.IsAlreadyExists(err) {
+				createdDp, err = clientset.AppsV1().Deployments(namespace).Get(ctx, dp.Name, metav1.GetOptions{})
+			}
+			retried = true
+			return err
+		})
+	}
+	var verb string
+	var err error
+	if !exists {
+		verb = "create"
+		err = createDp()
+		if err == nil {
+			*created = append(*created, deleteDp)
+		}
+	} else {
+		verb = "update"
+		err = withRetry(ctx, func() (err error) {
+			createdDp, err = serverSideApply(ctx, clientset, dp)
+			return err
+		})
+	}
+	if err != nil {
+		// Don't delete the devpod with --force just because of an interrupt.
+		if !opts.Force || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to %s devpod %q in namespace %q, you can use --force to delete it and re-create: %w", verb, dp.Name, namespace, err)
+		}
+		dp.UID = ""
+		opts.logf("Devpod %s/%s already exists, removing and re-creating since --force was set.\n", namespace, dp.Name)
+		err := withRetry(ctx, func() error {
+			return deleteDp(ctx)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete and re-create devpod named %q in namespace %q: %w", dp.Name, namespace, err)
+		}
+		if err := createDp(); err != nil {
+			return nil, fmt.Errorf("failed to re-create devpod named %q in namespace %q: %w", dp.Name, namespace, err)
+		}
+		*created = append(*created, deleteDp)
+	}
+	return createdDp, nil
+}
+
+// fieldManager owns the fields of the devpods applied server side.
+const fieldManager = "devpod"
+
+// serverSideApply updates the existing devpod dp with a server side apply, so
+// running devpod again updates it in place like kubectl apply would instead of
+// clobbering fields set by other managers or failing on a stale resource
+// version. devpod forces ownership of any conflicting fields since it owns the
+// devpod.
+func serverSideApply(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment) (*appsv1.Deployment, error) {
+	patch := dp.DeepCopy()
+	patch.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+	// Apply requests can't set these, they belong to the server.
+	patch.ManagedFields = nil
+	patch.ResourceVersion = ""
+	patch.Status = appsv1.DeploymentStatus{}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	force := true
+	return clientset.AppsV1().Deployments(dp.Namespace).Patch(ctx, dp.Name, k8stypes.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        &force,
+	})
+}
+
+// applyInitConfigMap creates or updates the ConfigMap holding the init scripts,
+// it reports if the ConfigMap is new.
+func applyInitConfigMap(ctx context.Context, clientset kubernetes.Interface, cm *v1.ConfigMap) (bool, error) {
+	existingCm, err := clientset.CoreV1().ConfigMaps(cm.Namespace).Get(ctx, cm.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			return false, fmt.Errorf("failed to check for configmap %q in namespace %q: %w", cm.Name, cm.Namespace, err)
+		}
+		// Need to create
+		_, err := clientset.CoreV1().ConfigMaps(cm.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to create configmap %q in namespace %q: %w", cm.Name, cm.Namespace, err)
+		}
+		return true, nil
+	}
+	// Need to update
+	cm.UID = existingCm.UID
+	_, err = clientset.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to update configmap %q in namespace %q: %w", cm.Name, cm.Namespace, err)
+	}
+	return false, nil
+}
@@ -0,0 +1,375 @@
+package devpod
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fernferret/devpod/pkg/image"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// sourceDeployment is the deployment the devpods in the tests are made from.
+func sourceDeployment() *appsv1.Deployment {
+	labels := map[string]string{"app": "api"}
+	replicas := int32(3)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api",
+			Namespace: "default",
+			Labels:    labels,
+			UID:       "source-uid",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "api"}},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:           "app",
+						Image:          "registry.example.com/api:1.0",
+						Args:           []string{"--listen", ":8080"},
+						ReadinessProbe: &v1.Probe{},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// newClientset returns a fake clientset holding objects. The object tracker
+// of the fake doesn't support server side apply, so apply patches of
+// deployments replace the stored deployment instead.
+func newClientset(objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != k8stypes.ApplyPatchType {
+			return false, nil, nil
+		}
+		dp := &appsv1.Deployment{}
+		if err := json.Unmarshal(patch.GetPatch(), dp); err != nil {
+			return true, nil, err
+		}
+		err := clientset.Tracker().Update(appsv1.SchemeGroupVersion.WithResource("deployments"), dp, patch.GetNamespace())
+		return true, dp, err
+	})
+	return clientset
+}
+
+// testOptions returns options that read the image details from a digest file
+// instead of the registry.
+func testOptions(t *testing.T) *Options {
+	t.Helper()
+	digestFile := filepath.Join(t.TempDir(), "digests.json")
+	cache := image.DigestCache{
+		"registry.example.com/api:1.0": {Entrypoint: []string{"/api"}},
+		"registry.example.com/api:2.0": {Entrypoint: []string{"/api"}},
+	}
+	if err := image.SaveDigestCache(digestFile, cache); err != nil {
+		t.Fatal(err)
+	}
+	return &Options{
+		ImageDigestFile: digestFile,
+		MaxHistoryLimit: 2,
+	}
+}
+
+// buildAndApply builds the devpod of the api deployment and applies it.
+func buildAndApply(t *testing.T, clientset *fake.Clientset, opts *Options) {
+	t.Helper()
+	ctx := context.Background()
+	devpod, err := Build(ctx, clientset, "api", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if _, err := Apply(ctx, clientset, devpod, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+}
+
+func TestApplyCreate(t *testing.T) {
+	ctx := context.Background()
+	clientset := newClientset(sourceDeployment())
+	buildAndApply(t, clientset, testOptions(t))
+
+	dp, err := clientset.AppsV1().Deployments("default").Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("devpod deployment wasn't created: %v", err)
+	}
+	if dp.UID != "" {
+		t.Errorf("devpod has the UID %q of the source", dp.UID)
+	}
+	if *dp.Spec.Replicas != 1 {
+		t.Errorf("replicas = %d, want 1", *dp.Spec.Replicas)
+	}
+	wantSelector := map[string]string{"app": "api-devpod", "devpod": "devpod"}
+	for key, val := range wantSelector {
+		if dp.Spec.Selector.MatchLabels[key] != val {
+			t.Errorf("selector label %s = %q, want %q", key, dp.Spec.Selector.MatchLabels[key], val)
+		}
+		if dp.Spec.Template.Labels[key] != val {
+			t.Errorf("template label %s = %q, want %q", key, dp.Spec.Template.Labels[key], val)
+		}
+	}
+	if !isDevpod(dp) {
+		t.Error("isDevpod() = false for the devpod")
+	}
+
+	container := dp.Spec.Template.Spec.Containers[0]
+	if strings.Join(container.Command, " ") != "sh -c" || !strings.Contains(container.Args[0], "sleep infinity") {
+		t.Errorf("container doesn't sleep: %q %q", container.Command, container.Args)
+	}
+	if container.ReadinessProbe != nil {
+		t.Error("readiness probe wasn't removed")
+	}
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != initScriptsDir {
+		t.Errorf("init scripts aren't mounted: %+v", container.VolumeMounts)
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps("default").Get(ctx, "api-devpod-init", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("init configmap wasn't created: %v", err)
+	}
+	script, ok := cm.Data["0_app.sh"]
+	if !ok {
+		t.Fatalf("init script is missing from the configmap: %v", cm.Data)
+	}
+	if got := lastLine(script); got != "/api --listen :8080" {
+		t.Errorf("init script runs %q, want %q", got, "/api --listen :8080")
+	}
+
+	// The source must be left alone.
+	source, err := clientset.AppsV1().Deployments("default").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *source.Spec.Replicas != 3 || source.Spec.Template.Spec.Containers[0].Command != nil {
+		t.Errorf("source deployment was changed: %+v", source.Spec)
+	}
+}
+
+func TestApplyCreateRetriedAfterTimeout(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	// The first create goes through but times out before the response, so
+	// the retry finds the devpod already there.
+	timedOut := false
+	clientset.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if timedOut {
+			return false, nil, nil
+		}
+		timedOut = true
+		create := action.(k8stesting.CreateAction)
+		if err := clientset.Tracker().Create(action.GetResource(), create.GetObject(), create.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, nil, k8serr.NewTimeoutError("create deployment", 1)
+	})
+	ctx := context.Background()
+	opts := testOptions(t)
+	devpod, err := Build(ctx, clientset, "api", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	dp, err := Apply(ctx, clientset, devpod, opts)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if dp == nil || dp.Name != "api-devpod" {
+		t.Errorf("Apply() = %+v, want the devpod created by the timed out attempt", dp)
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	ctx := context.Background()
+	clientset := newClientset(sourceDeployment())
+	buildAndApply(t, clientset, testOptions(t))
+
+	// Roll the source forward and run devpod again.
+	source, err := clientset.AppsV1().Deployments("default").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Spec.Template.Spec.Containers[0].Image = "registry.example.com/api:2.0"
+	source.Spec.Template.Spec.Containers[0].Args = []string{"--listen", ":9090"}
+	if _, err := clientset.AppsV1().Deployments("default").Update(ctx, source, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	buildAndApply(t, clientset, testOptions(t))
+
+	list, err := clientset.AppsV1().Deployments("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 2 {
+		t.Fatalf("got %d deployments after the update, want the source and its devpod", len(list.Items))
+	}
+	dp, err := clientset.AppsV1().Deployments("default").Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dp.Spec.Template.Spec.Containers[0].Image; got != "registry.example.com/api:2.0" {
+		t.Errorf("image = %q after the update, want registry.example.com/api:2.0", got)
+	}
+	// The selector is immutable, so it must be the same as the first time.
+	if got := dp.Spec.Selector.MatchLabels["app"]; got != "api-devpod" {
+		t.Errorf("selector label app = %q after the update, want api-devpod", got)
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps("default").Get(ctx, "api-devpod-init", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastLine(cm.Data["0_app.sh"]); got != "/api --listen :9090" {
+		t.Errorf("init script runs %q after the update, want %q", got, "/api --listen :9090")
+	}
+}
+
+func TestBuildCreateOnly(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	buildAndApply(t, clientset, testOptions(t))
+
+	opts := testOptions(t)
+	opts.CreateOnly = true
+	if _, err := Build(context.Background(), clientset, "api", "deployment", "default", opts); err == nil {
+		t.Error("Build() didn't fail with --create-only for an existing devpod")
+	}
+}
+
+func TestBuildUpdateOnly(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	opts := testOptions(t)
+	opts.UpdateOnly = true
+	if _, err := Build(context.Background(), clientset, "api", "deployment", "default", opts); err == nil {
+		t.Error("Build() didn't fail with --update-only for a missing devpod")
+	}
+}
+
+func TestBuildMissingSource(t *testing.T) {
+	clientset := newClientset()
+	if _, err := Build(context.Background(), clientset, "api", "deployment", "default", testOptions(t)); err == nil {
+		t.Error("Build() didn't fail for a missing source deployment")
+	}
+}
+
+func TestApplyNoConfigMap(t *testing.T) {
+	ctx := context.Background()
+	clientset := newClientset(sourceDeployment())
+	opts := testOptions(t)
+	opts.NoConfigMap = true
+	buildAndApply(t, clientset, opts)
+
+	dp, err := clientset.AppsV1().Deployments("default").Get(ctx, "api-devpod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("devpod deployment wasn't created: %v", err)
+	}
+	if len(dp.Spec.Template.Spec.Volumes) != 0 {
+		t.Errorf("volumes = %+v, want none with --no-configmap", dp.Spec.Template.Spec.Volumes)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("default").Get(ctx, "api-devpod-init", metav1.GetOptions{}); err == nil {
+		t.Error("init configmap was created with --no-configmap")
+	}
+}
+
+func TestBuildMirrorsInjectedImages(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	opts := testOptions(t)
+	// The mirrored images aren't in the digest file, don't inspect them.
+	opts.NoConfigMap = true
+	opts.EnableDebugSidecar = true
+	opts.DebugImage = "nicolaka/netshoot"
+	opts.DevtoolsImage = "busybox:1.36"
+	opts.ImageRegistryMirrors = map[string]string{
+		"docker.io":            "mirror.internal/docker",
+		"registry.example.com": "mirror.internal/example",
+	}
+	devpod, err := Build(context.Background(), clientset, "api", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	pod := devpod.Deployment.Spec.Template.Spec
+	want := map[string]string{
+		"app":            "mirror.internal/example/api:1.0",
+		DebugSidecarName: "mirror.internal/docker/nicolaka/netshoot",
+		devtoolsVolume:   "mirror.internal/docker/library/busybox:1.36",
+	}
+	for _, container := range append(pod.InitContainers, pod.Containers...) {
+		if container.Image != want[container.Name] {
+			t.Errorf("image of container %q = %q, want %q", container.Name, container.Image, want[container.Name])
+		}
+		delete(want, container.Name)
+	}
+	for name := range want {
+		t.Errorf("container %q is missing", name)
+	}
+}
+
+func TestBuildImageTagOverride(t *testing.T) {
+	source := sourceDeployment()
+	pod := &source.Spec.Template.Spec
+	pod.InitContainers = []v1.Container{{Name: "migrate", Image: "registry.example.com/migrate:1.0"}}
+	pod.Containers = append(pod.Containers, v1.Container{Name: "proxy", Image: "registry.example.com/proxy@sha256:" + strings.Repeat("a", 64)})
+	clientset := newClientset(source)
+	opts := testOptions(t)
+	opts.Containers = []string{"app"}
+	opts.EnableDebugSidecar = true
+	opts.DebugImage = "nicolaka/netshoot:v0.11"
+	opts.ImageTagOverride = "2.0"
+	devpod, err := Build(context.Background(), clientset, "api", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	want := map[string]string{
+		"migrate":        "registry.example.com/migrate:2.0",
+		"app":            "registry.example.com/api:2.0",
+		"proxy":          "registry.example.com/proxy:2.0",
+		DebugSidecarName: "nicolaka/netshoot:v0.11",
+	}
+	built := devpod.Deployment.Spec.Template.Spec
+	for _, container := range append(built.InitContainers, built.Containers...) {
+		if image, ok := want[container.Name]; ok && container.Image != image {
+			t.Errorf("image of container %q = %q, want %q", container.Name, container.Image, image)
+		}
+	}
+}
+
+func TestBuildGracefulShutdownHook(t *testing.T) {
+	source := sourceDeployment()
+	gracePeriod := int64(45)
+	source.Spec.Template.Spec.TerminationGracePeriodSeconds = &gracePeriod
+
+	devpod, err := Build(context.Background(), newClientset(source), "api", "deployment", "default", testOptions(t))
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if got := *devpod.Deployment.Spec.Template.Spec.TerminationGracePeriodSeconds; got != 1 {
+		t.Errorf("grace period = %d without --graceful-shutdown-hook, want 1", got)
+	}
+
+	opts := testOptions(t)
+	opts.GracefulShutdownSignal = "SIGTERM"
+	devpod, err = Build(context.Background(), newClientset(source), "api", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	pod := devpod.Deployment.Spec.Template.Spec
+	if got := *pod.TerminationGracePeriodSeconds; got != gracePeriod {
+		t.Errorf("grace period = %d with --graceful-shutdown-hook, want the %d of the source", got, gracePeriod)
+	}
+	if args := pod.Containers[0].Args[0]; !strings.Contains(args, "kill -s TERM -- -1") || !strings.HasSuffix(args, "sleep infinity &\nwait") {
+		t.Errorf("the sleeping shell doesn't forward TERM:\n%s", args)
+	}
+	if script := devpod.ConfigMap.Data["0_app.sh"]; !strings.Contains(script, "trap 'echo \"Received TERM, shutting down...\"' TERM") {
+		t.Errorf("init script doesn't trap TERM:\n%s", script)
+	}
+}
@@ -0,0 +1,40 @@
+package devpod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	appsv1 "k8s.io/api/apps/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// readDeployment reads the Deployment named name from the YAML or JSON
+// manifests in the file at path, the first Deployment is used if name is
+// empty. Any other kind of object in the file is skipped.
+func readDeployment(path, name string) (*appsv1.Deployment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		dp := &appsv1.Deployment{}
+		if err := decoder.Decode(dp); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to parse %q: %w", path, err)
+		}
+		if dp.Kind != "Deployment" || (name != "" && dp.Name != name) {
+			continue
+		}
+		return dp, nil
+	}
+	if name != "" {
+		return nil, fmt.Errorf("no deployment %q in %q", name, path)
+	}
+	return nil, fmt.Errorf("no deployment in %q", path)
+}
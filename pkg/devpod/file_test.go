@@ -0,0 +1,98 @@
+package devpod
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeManifest writes a deployment manifest with the given selector and
+// template metadata to a temporary file and returns its path.
+func writeManifest(t *testing.T, selector, templateMeta string) string {
+	t.Helper()
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+` + selector + `
+  template:
+` + templateMeta + `
+    spec:
+      containers:
+      - name: app
+        image: registry.example.com/api:1.0
+`
+	path := filepath.Join(t.TempDir(), "deployment.yaml")
+	if err := os.WriteFile(path, []byte(manifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildFromFile(t *testing.T) {
+	opts := testOptions(t)
+	opts.FromFile = writeManifest(t, `  selector:
+    matchLabels:
+      app: api`, `    metadata:
+      labels:
+        app: api`)
+	devpod, err := Build(context.Background(), nil, "", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if got := devpod.Deployment.Name; got != "api-devpod" {
+		t.Errorf("devpod name = %q, want api-devpod", got)
+	}
+	if got := devpod.Deployment.Namespace; got != "default" {
+		t.Errorf("devpod namespace = %q, want default", got)
+	}
+}
+
+func TestBuildFromFileWithoutTemplateLabels(t *testing.T) {
+	opts := testOptions(t)
+	opts.FromFile = writeManifest(t, `  selector:
+    matchLabels:
+      app: api`, `    metadata: {}`)
+	devpod, err := Build(context.Background(), nil, "", "deployment", "default", opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	labels := devpod.Deployment.Spec.Template.Labels
+	for key, val := range devpod.Deployment.Spec.Selector.MatchLabels {
+		if labels[key] != val {
+			t.Errorf("template label %s = %q, the selector wants %q", key, labels[key], val)
+		}
+	}
+}
+
+func TestBuildFromFileInvalidSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+	}{
+		{name: "no selector", selector: `  replicas: 1`},
+		{name: "only match expressions", selector: `  selector:
+    matchExpressions:
+    - key: app
+      operator: In
+      values: [api]`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.FromFile = writeManifest(t, tc.selector, `    metadata:
+      labels:
+        app: api`)
+			_, err := Build(context.Background(), nil, "", "deployment", "default", opts)
+			if err == nil {
+				t.Fatal("Build() didn't fail for a deployment without match labels")
+			}
+			if !strings.Contains(err.Error(), "matchLabels") {
+				t.Errorf("error %q doesn't mention the match labels", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,686 @@
+package devpod
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// File is a generated file that's written to Path, relative to the working
+// directory, instead of being printed like the other manifests.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// GenerateManifests builds every manifest requested with one of the Generate
+// options for the devpod, a *File is written instead of printed. An empty list
+// means nothing was requested and the devpod should be applied as usual.
+func GenerateManifests(devpod *Devpod, opts *Options) ([]interface{}, error) {
+	dp := devpod.Deployment
+	manifests := []interface{}{}
+	if opts.GenerateFluxKustomization {
+		manifests = append(manifests, fluxKustomization(dp, opts.FluxSource, opts.FluxPath))
+	}
+	if opts.GenerateTelepresenceConfig {
+		manifests = append(manifests, telepresenceConfig(dp, opts))
+	}
+	if opts.GenerateHelmValues {
+		values, err := helmValues(dp, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Helm values for devpod %q: %w", dp.Name, err)
+		}
+		manifests = append(manifests, values)
+	}
+	if opts.GenerateKindConfig {
+		manifests = append(manifests, kindConfig(dp))
+	}
+	if opts.GenerateDevfile {
+		manifests = append(manifests, devfile(devpod, opts))
+	}
+	if opts.GeneratePolicyException {
+		exception, err := policyException(dp, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate policy exception for devpod %q: %w", dp.Name, err)
+		}
+		manifests = append(manifests, exception)
+	}
+	if opts.GenerateArgoRollout {
+		manifests = append(manifests, argoRollout(dp))
+	}
+	if opts.GenerateConftestPolicy {
+		manifests = append(manifests, conftestPolicy(dp, opts))
+	}
+	if opts.GenerateOPABundle {
+		manifests = append(manifests, gatekeeperBundle(dp, opts)...)
+	}
+	return manifests, nil
+}
+
+// fluxKustomization builds a Flux Kustomization that reconciles the overlay for
+// the devpod dp from the GitRepository named source. If path is empty the
+// overlay is expected at ./devpod/{namespace}/{name}.
+func fluxKustomization(dp *appsv1.Deployment, source, path string) map[string]interface{} {
+	if path == "" {
+		path = fmt.Sprintf("./devpod/%s/%s", dp.Namespace, dp.Name)
+	}
+	return map[string]interface{}{
+		"apiVersion": "kustomize.toolkit.fluxcd.io/v1",
+		"kind":       "Kustomization",
+		"metadata": map[string]interface{}{
+			"name":      dp.Name,
+			"namespace": "flux-system",
+		},
+		"spec": map[string]interface{}{
+			"interval":        "10m",
+			"path":            path,
+			"prune":           true,
+			"targetNamespace": dp.Namespace,
+			"sourceRef": map[string]interface{}{
+				"kind": "GitRepository",
+				"name": source,
+			},
+		},
+	}
+}
+
+// telepresenceConfig builds a Telepresence intercept spec that intercepts every
+// container port of the devpod dp, so traffic is sent to the local machine
+// instead of the production deployment. Containers that kept running, like
+// sidecars, are skipped since they keep handling their own traffic.
+func telepresenceConfig(dp *appsv1.Deployment, opts *Options) map[string]interface{} {
+	intercepts := []interface{}{}
+	for _, container := range dp.Spec.Template.Spec.Containers {
+		if !opts.IsDebugContainer(container.Name) {
+			continue
+		}
+		for _, port := range container.Ports {
+			intercepts = append(intercepts, map[string]interface{}{
+				"name":      fmt.Sprintf("%s-%d", container.Name, port.ContainerPort),
+				"port":      port.ContainerPort,
+				"localPort": port.ContainerPort,
+			})
+		}
+	}
+	return map[string]interface{}{
+		"name": dp.Name,
+		"workloads": []interface{}{
+			map[string]interface{}{
+				"name":       dp.Name,
+				"namespace":  dp.Namespace,
+				"intercepts": intercepts,
+			},
+		},
+	}
+}
+
+// helmValues builds a values file for the chart at HelmChart that makes a
+// release behave like the devpod dp. Charts don't share a values schema, so
+// only the well known top level keys (the ones `helm create` scaffolds) that
+// exist in the chart's values.yaml are overridden.
+func helmValues(dp *appsv1.Deployment, opts *Options) (map[string]interface{}, error) {
+	if opts.HelmChart == "" {
+		return nil, errors.New("--helm-chart is required with --generate-helm-values")
+	}
+	data, err := os.ReadFile(filepath.Join(opts.HelmChart, "values.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	chartValues := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &chartValues); err != nil {
+		return nil, fmt.Errorf("unable to parse values.yaml of chart %q: %w", opts.HelmChart, err)
+	}
+
+	overrides := map[string]interface{}{
+		"replicaCount": 1,
+		"autoscaling":  map[string]interface{}{"enabled": false},
+	}
+	if !opts.KeepProbes {
+		// A null value removes the key from the chart defaults.
+		overrides["livenessProbe"] = nil
+		overrides["readinessProbe"] = nil
+		overrides["startupProbe"] = nil
+	}
+	for _, container := range dp.Spec.Template.Spec.Containers {
+		if opts.IsDebugContainer(container.Name) {
+			overrides["command"] = container.Command
+			overrides["args"] = container.Args
+			break
+		}
+	}
+
+	values := map[string]interface{}{}
+	for key, val := range overrides {
+		if _, ok := chartValues[key]; !ok {
+			opts.logf("WARNING: Chart %q has no %q value, it can't be overridden\n", opts.HelmChart, key)
+			continue
+		}
+		values[key] = val
+	}
+	return values, nil
+}
+
+// kindConfig builds a kind cluster config for reproducing the devpod dp
+// locally. The hostPath volumes of the devpod are mounted into the node at the
+// same path and every container port is mapped from the host, using its
+// hostPort when it has one, so the pod can be reached with a hostPort or
+// NodePort of the same number.
+func kindConfig(dp *appsv1.Deployment) map[string]interface{} {
+	mounts := []interface{}{}
+	for _, volume := range dp.Spec.Template.Spec.Volumes {
+		if volume.HostPath == nil {
+			continue
+		}
+		mounts = append(mounts, map[string]interface{}{
+			"hostPath":      volume.HostPath.Path,
+			"containerPath": volume.HostPath.Path,
+		})
+	}
+
+	ports := []interface{}{}
+	seen := map[string]bool{}
+	for _, container := range dp.Spec.Template.Spec.Containers {
+		for _, port := range container.Ports {
+			hostPort := port.HostPort
+			if hostPort == 0 {
+				hostPort = port.ContainerPort
+			}
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			// The same host port can only be mapped once.
+			key := fmt.Sprintf("%d/%s", hostPort, protocol)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ports = append(ports, map[string]interface{}{
+				"containerPort": hostPort,
+				"hostPort":      hostPort,
+				"protocol":      protocol,
+			})
+		}
+	}
+
+	node := map[string]interface{}{
+		"role": "control-plane",
+	}
+	if len(mounts) > 0 {
+		node["extraMounts"] = mounts
+	}
+	if len(ports) > 0 {
+		node["extraPortMappings"] = ports
+	}
+	return map[string]interface{}{
+		"apiVersion": "kind.x-k8s.io/v1alpha4",
+		"kind":       "Cluster",
+		"name":       dp.Name,
+		"nodes":      []interface{}{node},
+	}
+}
+
+// devfileScriptDir is where the devfile postStart event writes the init
+// scripts, the Dev Spaces workspace doesn't get the devpod ConfigMap.
+const devfileScriptDir = "/tmp/devpod"
+
+// devfile builds a Devfile v2 for Eclipse Che / OpenShift Dev Spaces that
+// starts the containers of the devpod the same way: the debug containers sleep,
+// a postStart event writes their init scripts and a run command per container
+// starts the original entrypoint.
+func devfile(devpod *Devpod, opts *Options) map[string]interface{} {
+	dp := devpod.Deployment
+	components := []interface{}{}
+	commands := []interface{}{}
+	postStart := []interface{}{}
+	var scripts map[string]string
+	if devpod.ConfigMap != nil {
+		scripts = devpod.ConfigMap.Data
+	}
+	for idx, container := range dp.Spec.Template.Spec.Containers {
+		spec := map[string]interface{}{
+			"image":        container.Image,
+			"mountSources": false,
+		}
+		if len(container.Command) > 0 {
+			spec["command"] = container.Command
+		}
+		if len(container.Args) > 0 {
+			spec["args"] = container.Args
+		}
+		env := []interface{}{}
+		for _, item := range container.Env {
+			// Values from secrets, configmaps and the Downward API can't be
+			// expressed in a devfile.
+			if item.ValueFrom != nil {
+				continue
+			}
+			env = append(env, map[string]interface{}{"name": item.Name, "value": item.Value})
+		}
+		if len(env) > 0 {
+			spec["env"] = env
+		}
+		endpoints := []interface{}{}
+		for _, port := range container.Ports {
+			name := port.Name
+			if name == "" {
+				name = fmt.Sprintf("%s-%d", container.Name, port.ContainerPort)
+			}
+			endpoints = append(endpoints, map[string]interface{}{
+				"name":       name,
+				"targetPort": port.ContainerPort,
+			})
+		}
+		if len(endpoints) > 0 {
+			spec["endpoints"] = endpoints
+		}
+		if limits := container.Resources.Limits; limits != nil {
+			if cpu, ok := limits[v1.ResourceCPU]; ok {
+				spec["cpuLimit"] = cpu.String()
+			}
+			if memory, ok := limits[v1.ResourceMemory]; ok {
+				spec["memoryLimit"] = memory.String()
+			}
+		}
+		components = append(components, map[string]interface{}{
+			"name":      container.Name,
+			"container": spec,
+		})
+
+		script, ok := scripts[initScriptName(idx, container.Name)]
+		if !opts.IsDebugContainer(container.Name) || !ok {
+			continue
+		}
+		path := fmt.Sprintf("%s/%s", devfileScriptDir, initScriptName(idx, container.Name))
+		installID := fmt.Sprintf("install-%s", container.Name)
+		commands = append(commands, map[string]interface{}{
+			"id": installID,
+			"exec": map[string]interface{}{
+				"component":   container.Name,
+				"commandLine": fmt.Sprintf("mkdir -p %s && cat > %s <<'DEVPOD_EOF'\n%sDEVPOD_EOF", devfileScriptDir, path, script),
+			},
+		})
+		commands = append(commands, map[string]interface{}{
+			"id": fmt.Sprintf("run-%s", container.Name),
+			"exec": map[string]interface{}{
+				"component":   container.Name,
+				"commandLine": fmt.Sprintf("sh %s", path),
+				"group": map[string]interface{}{
+					"kind":      "run",
+					"isDefault": len(postStart) == 0,
+				},
+			},
+		})
+		postStart = append(postStart, installID)
+	}
+	return map[string]interface{}{
+		"schemaVersion": "2.2.0",
+		"metadata": map[string]interface{}{
+			"name": dp.Name,
+		},
+		"components": components,
+		"commands":   commands,
+		"events": map[string]interface{}{
+			"postStart": postStart,
+		},
+	}
+}
+
+// policyExceptionRules are the Kyverno policy library rules a devpod can break,
+// used reports if the devpod makes the change that breaks the rule.
+var policyExceptionRules = []struct {
+	policy string
+	rule   string
+	used   func(opts *Options) bool
+}{
+	{"require-pod-probes", "validate-probes", func(opts *Options) bool { return !opts.KeepProbes }},
+	{"require-requests-limits", "validate-resources", func(opts *Options) bool { return opts.StripResourceLimits }},
+	{"disallow-privileged-containers", "privileged-containers", func(opts *Options) bool { return opts.Privileged }},
+	// The debug sidecar adds SYS_PTRACE to trace the processes of the other
+	// containers, sharing the process namespace only reaches inside the pod.
+	{"disallow-capabilities", "adding-capabilities", func(opts *Options) bool {
+		return len(opts.AddCaps) > 0 || opts.AddCapAll || opts.EnableDebugSidecar
+	}},
+	{"require-run-as-nonroot", "run-as-non-root", func(opts *Options) bool { return opts.RunAsRoot }},
+}
+
+// policyException builds a Kyverno PolicyException that exempts the devpod dp,
+// its ReplicaSets and pods from the policies its changes break, like removing
+// the probes or the resource limits. The policies are the ones from the Kyverno
+// policy library, the autogen rules Kyverno adds for the pod controllers are
+// exempted too.
+func policyException(dp *appsv1.Deployment, opts *Options) (map[string]interface{}, error) {
+	exceptions := []interface{}{}
+	for _, item := range policyExceptionRules {
+		if !item.used(opts) {
+			continue
+		}
+		exceptions = append(exceptions, map[string]interface{}{
+			"policyName": item.policy,
+			"ruleNames":  []string{item.rule, "autogen-" + item.rule},
+		})
+	}
+	if len(exceptions) == 0 {
+		return nil, errors.New("nothing the devpod changes needs an exception, the probes are kept and no security or resource settings are changed")
+	}
+	return map[string]interface{}{
+		"apiVersion": "kyverno.io/v2",
+		"kind":       "PolicyException",
+		"metadata": map[string]interface{}{
+			"name":      dp.Name,
+			"namespace": dp.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"exceptions": exceptions,
+			"match": map[string]interface{}{
+				"any": []interface{}{
+					map[string]interface{}{
+						"resources": map[string]interface{}{
+							"kinds":      []string{"Deployment", "ReplicaSet", "Pod"},
+							"namespaces": []string{dp.Namespace},
+							// The ReplicaSets and pods are named after the
+							// devpod with a generated suffix.
+							"names": []string{dp.Name, dp.Name + "-*"},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// argoRollout builds an Argo Rollout with the spec of the devpod dp and a
+// simple canary strategy that moves 20% of the traffic over and then waits to
+// be promoted.
+func argoRollout(dp *appsv1.Deployment) map[string]interface{} {
+	spec := map[string]interface{}{
+		"replicas":                dp.Spec.Replicas,
+		"selector":                dp.Spec.Selector,
+		"template":                dp.Spec.Template,
+		"revisionHistoryLimit":    dp.Spec.RevisionHistoryLimit,
+		"progressDeadlineSeconds": dp.Spec.ProgressDeadlineSeconds,
+		"strategy": map[string]interface{}{
+			"canary": map[string]interface{}{
+				"steps": []interface{}{
+					map[string]interface{}{"setWeight": 20},
+					map[string]interface{}{"pause": map[string]interface{}{}},
+				},
+			},
+		},
+	}
+	if dp.Spec.Paused {
+		spec["paused"] = true
+	}
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata": map[string]interface{}{
+			"name":        dp.Name,
+			"namespace":   dp.Namespace,
+			"labels":      dp.Labels,
+			"annotations": dp.Annotations,
+		},
+		"spec": spec,
+	}
+}
+
+// conftestPolicyPath is where conftest looks for policies by default.
+const conftestPolicyPath = "policy/devpod.rego"
+
+var conftestPolicyTemplate = template.Must(template.New("conftest").Parse(`# Conftest policy for devpod manifests, generated by devpod for {{ .Name }}.
+# Run it with: conftest test devpod.yaml
+package main
+
+import rego.v1
+
+debug_containers := { {{- .DebugContainers -}} }
+
+is_devpod if {
+	input.kind == "Deployment"
+	endswith(input.metadata.name, "-devpod")
+}
+
+deny contains msg if {
+	is_devpod
+	not input.spec.template.metadata.labels.devpod == "devpod"
+	msg := sprintf("devpod %s must have the devpod=devpod pod label", [input.metadata.name])
+}
+
+deny contains msg if {
+	is_devpod
+	not input.spec.replicas == 1
+	msg := sprintf("devpod %s must have exactly 1 replica", [input.metadata.name])
+}
+{{ if .CheckProbes }}
+deny contains msg if {
+	is_devpod
+	some container in input.spec.template.spec.containers
+	container.name in debug_containers
+	some probe in ["livenessProbe", "readinessProbe", "startupProbe"]
+	container[probe]
+	msg := sprintf("container %s of devpod %s must not have a %s", [container.name, input.metadata.name, probe])
+}
+{{ end }}
+# Every key of an init ConfigMap is an init script named {index}_{container}.sh.
+is_init_configmap if {
+	input.kind == "ConfigMap"
+	count(input.data) > 0
+	every key in object.keys(input.data) {
+		regex.match("^[0-9]+_.+\\.sh$", key)
+	}
+}
+
+deny contains msg if {
+	is_init_configmap
+	not endswith(input.metadata.name, "-devpod-init")
+	msg := sprintf("init configmap %s must be named {deployment}-devpod-init", [input.metadata.name])
+}
+`))
+
+// conftestPolicy builds a Rego policy for conftest that checks devpod
+// manifests look like the devpod dp: the devpod label is set, there's a single
+// replica, the debug containers have no probes unless KeepProbes is set, and
+// the init ConfigMap is named after the source deployment.
+func conftestPolicy(dp *appsv1.Deployment, opts *Options) *File {
+	names := []string{}
+	for _, container := range dp.Spec.Template.Spec.Containers {
+		if opts.IsDebugContainer(container.Name) {
+			names = append(names, strconv.Quote(container.Name))
+		}
+	}
+	var buf bytes.Buffer
+	// The template is fixed and only gets strings, it can't fail.
+	conftestPolicyTemplate.Execute(&buf, map[string]interface{}{
+		"Name":            dp.Name,
+		"DebugContainers": strings.Join(names, ", "),
+		"CheckProbes":     !opts.KeepProbes,
+	})
+	return &File{Path: conftestPolicyPath, Data: buf.Bytes()}
+}
+
+// gatekeeperIsDevpod is shared by the Gatekeeper templates, devpods are the
+// Deployments whose pods have the devpod label.
+const gatekeeperIsDevpod = `
+is_devpod {
+  input.review.kind.kind == "Deployment"
+  input.review.object.spec.template.metadata.labels.devpod == "devpod"
+}
+`
+
+// gatekeeperTemplates are the Gatekeeper ConstraintTemplates enforcing who can
+// create devpods, in which namespaces and with which resource limits. The
+// parameters of each are the properties of its constraint.
+var gatekeeperTemplates = []struct {
+	kind       string
+	constraint string
+	properties map[string]interface{}
+	rego       string
+}{
+	{
+		kind:       "K8sDevpodAllowedUsers",
+		constraint: "devpod-allowed-users",
+		properties: map[string]interface{}{
+			"users":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"groups": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+		rego: `package k8sdevpodallowedusers
+` + gatekeeperIsDevpod + `
+allowed {
+  input.review.userInfo.username == input.parameters.users[_]
+}
+
+allowed {
+  input.review.userInfo.groups[_] == input.parameters.groups[_]
+}
+
+# The audit has no user to check, only admission requests are denied.
+violation[{"msg": msg}] {
+  is_devpod
+  username := input.review.userInfo.username
+  not allowed
+  msg := sprintf("%v is not allowed to create devpods", [username])
+}
+`,
+	},
+	{
+		kind:       "K8sDevpodAllowedNamespaces",
+		constraint: "devpod-allowed-namespaces",
+		properties: map[string]interface{}{
+			"namespaces": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+		rego: `package k8sdevpodallowednamespaces
+` + gatekeeperIsDevpod + `
+allowed_namespace(namespace) {
+  namespace == input.parameters.namespaces[_]
+}
+
+violation[{"msg": msg}] {
+  is_devpod
+  namespace := input.review.object.metadata.namespace
+  not allowed_namespace(namespace)
+  msg := sprintf("devpods are not allowed in namespace %v", [namespace])
+}
+`,
+	},
+	{
+		kind:       "K8sDevpodResourceLimits",
+		constraint: "devpod-resource-limits",
+		properties: map[string]interface{}{
+			"limits": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+		},
+		rego: `package k8sdevpodresourcelimits
+` + gatekeeperIsDevpod + `
+violation[{"msg": msg}] {
+  is_devpod
+  container := input.review.object.spec.template.spec.containers[_]
+  input.parameters.limits[resource]
+  not container.resources.limits[resource]
+  msg := sprintf("container %v of devpod %v must have a %v limit", [container.name, input.review.object.metadata.name, resource])
+}
+
+violation[{"msg": msg}] {
+  is_devpod
+  container := input.review.object.spec.template.spec.containers[_]
+  maximum := input.parameters.limits[resource]
+  limit := container.resources.limits[resource]
+  units.parse(sprintf("%v", [limit])) > units.parse(maximum)
+  msg := sprintf("the %v limit %v of container %v of devpod %v is more than %v", [resource, limit, container.name, input.review.object.metadata.name, maximum])
+}
+`,
+	},
+}
+
+// gatekeeperBundle builds the Gatekeeper ConstraintTemplates along with a
+// constraint for each, so only the OPAAllowedUsers and OPAAllowedGroups can
+// create devpods, only in the namespace of the devpod dp, and with limits no
+// larger than the ones of dp. Without any users or groups only cluster admins
+// are allowed.
+func gatekeeperBundle(dp *appsv1.Deployment, opts *Options) []interface{} {
+	groups := opts.OPAAllowedGroups
+	if len(groups) == 0 && len(opts.OPAAllowedUsers) == 0 {
+		groups = []string{"system:masters"}
+	}
+	largest := v1.ResourceList{}
+	for _, container := range dp.Spec.Template.Spec.Containers {
+		for name, quantity := range container.Resources.Limits {
+			if current, ok := largest[name]; !ok || quantity.Cmp(current) > 0 {
+				largest[name] = quantity
+			}
+		}
+	}
+	limits := map[string]string{}
+	for name, quantity := range largest {
+		limits[string(name)] = quantity.String()
+	}
+	parameters := map[string]map[string]interface{}{
+		"K8sDevpodAllowedUsers": {
+			"users":  emptyIfNil(opts.OPAAllowedUsers),
+			"groups": emptyIfNil(groups),
+		},
+		"K8sDevpodAllowedNamespaces": {"namespaces": []string{dp.Namespace}},
+		"K8sDevpodResourceLimits":    {"limits": limits},
+	}
+
+	manifests := []interface{}{}
+	constraints := []interface{}{}
+	for _, item := range gatekeeperTemplates {
+		name := strings.ToLower(item.kind)
+		manifests = append(manifests, map[string]interface{}{
+			"apiVersion": "templates.gatekeeper.sh/v1",
+			"kind":       "ConstraintTemplate",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec": map[string]interface{}{
+				"crd": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"names": map[string]interface{}{"kind": item.kind},
+						"validation": map[string]interface{}{
+							"openAPIV3Schema": map[string]interface{}{
+								"type":       "object",
+								"properties": item.properties,
+							},
+						},
+					},
+				},
+				"targets": []interface{}{
+					map[string]interface{}{
+						"target": "admission.k8s.gatekeeper.sh",
+						"rego":   item.rego,
+					},
+				},
+			},
+		})
+		constraints = append(constraints, map[string]interface{}{
+			"apiVersion": "constraints.gatekeeper.sh/v1beta1",
+			"kind":       item.kind,
+			"metadata":   map[string]interface{}{"name": item.constraint},
+			"spec": map[string]interface{}{
+				"match": map[string]interface{}{
+					"kinds": []interface{}{
+						map[string]interface{}{"apiGroups": []string{"apps"}, "kinds": []string{"Deployment"}},
+					},
+				},
+				"parameters": parameters[item.kind],
+			},
+		})
+	}
+	// The constraints can only be created once their templates are.
+	return append(manifests, constraints...)
+}
+
+// emptyIfNil returns an empty list for nil so it's marshalled as [] instead of
+// null.
+func emptyIfNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
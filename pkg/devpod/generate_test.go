@@ -0,0 +1,40 @@
+package devpod
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicyExceptionCapabilities(t *testing.T) {
+	dp := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api-devpod", Namespace: "default"}}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "add caps", opts: Options{AddCaps: []string{"NET_ADMIN"}}},
+		{name: "add cap all", opts: Options{AddCapAll: true}},
+		{name: "debug sidecar", opts: Options{EnableDebugSidecar: true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.KeepProbes = true
+			exception, err := policyException(dp, &tc.opts)
+			if err != nil {
+				t.Fatalf("policyException() error = %v", err)
+			}
+			exceptions := exception["spec"].(map[string]interface{})["exceptions"].([]interface{})
+			if len(exceptions) != 1 || exceptions[0].(map[string]interface{})["policyName"] != "disallow-capabilities" {
+				t.Errorf("exceptions = %v, want disallow-capabilities", exceptions)
+			}
+		})
+	}
+}
+
+func TestPolicyExceptionNothingChanged(t *testing.T) {
+	dp := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api-devpod", Namespace: "default"}}
+	if _, err := policyException(dp, &Options{KeepProbes: true}); err == nil {
+		t.Error("policyException() didn't fail when nothing needs an exception")
+	}
+}
@@ -0,0 +1,90 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sourceHorizontalPodAutoscaler returns the HorizontalPodAutoscaler targeting
+// the deployment named source in namespace, or nil if it isn't autoscaled.
+func sourceHorizontalPodAutoscaler(ctx context.Context, clientset kubernetes.Interface, source, namespace string) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	list, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for idx, item := range list.Items {
+		target := item.Spec.ScaleTargetRef
+		if target.Kind == "Deployment" && target.Name == source {
+			return &list.Items[idx], nil
+		}
+	}
+	return nil, nil
+}
+
+// warnHorizontalPodAutoscaler warns that the HorizontalPodAutoscaler of the
+// source deployment isn't copied, nothing is logged if the source isn't
+// autoscaled or the autoscalers can't be listed.
+func warnHorizontalPodAutoscaler(ctx context.Context, clientset kubernetes.Interface, source, namespace string, opts *Options) {
+	item, err := sourceHorizontalPodAutoscaler(ctx, clientset, source, namespace)
+	if err != nil || item == nil {
+		return
+	}
+	opts.logf("WARNING: Horizontalpodautoscaler %s/%s targets deployment %q, the devpod isn't autoscaled, use --keep-hpa to copy it\n", namespace, item.Name, source)
+}
+
+// copyHorizontalPodAutoscaler returns a copy of the HorizontalPodAutoscaler
+// targeting the source deployment in sourceNamespace that targets the devpod dp
+// instead, with
+// the replicas overridden by HPAMinReplicas and HPAMaxReplicas. It returns nil
+// if the source isn't autoscaled.
+func copyHorizontalPodAutoscaler(ctx context.Context, clientset kubernetes.Interface, source, sourceNamespace string, dp *appsv1.Deployment, opts *Options) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	item, err := sourceHorizontalPodAutoscaler(ctx, clientset, source, sourceNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list horizontalpodautoscalers for --copy-horizontal-pod-autoscaler: %w", err)
+	}
+	if item == nil {
+		opts.logf("WARNING: No horizontalpodautoscaler targets deployment %s/%s, nothing to copy\n", sourceNamespace, source)
+		return nil, nil
+	}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        Name(item.Name),
+			Namespace:   dp.Namespace,
+			Labels:      map[string]string{"devpod": "devpod"},
+			Annotations: map[string]string{"devpod": "Created by devpod"},
+		},
+		Spec: *item.Spec.DeepCopy(),
+	}
+	hpa.Spec.ScaleTargetRef.Name = dp.Name
+	if opts.HPAMinReplicas > 0 {
+		minReplicas := opts.HPAMinReplicas
+		hpa.Spec.MinReplicas = &minReplicas
+	}
+	if opts.HPAMaxReplicas > 0 {
+		hpa.Spec.MaxReplicas = opts.HPAMaxReplicas
+	}
+	if hpa.Spec.MinReplicas != nil && *hpa.Spec.MinReplicas > hpa.Spec.MaxReplicas {
+		return nil, fmt.Errorf("the min replicas (%d) of the devpod horizontalpodautoscaler are more than its max replicas (%d), see --hpa-min-replicas and --hpa-max-replicas", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+	return hpa, nil
+}
+
+// applyHorizontalPodAutoscaler creates the HorizontalPodAutoscaler or updates it
+// if it already exists.
+func applyHorizontalPodAutoscaler(ctx context.Context, clientset kubernetes.Interface, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	client := clientset.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace)
+	_, err := client.Create(ctx, hpa, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		_, err = client.Update(ctx, hpa, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply horizontalpodautoscaler %q in namespace %q: %w", hpa.Name, hpa.Namespace, err)
+	}
+	return nil
+}
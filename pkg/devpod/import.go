@@ -0,0 +1,59 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Import reads the deployment name in namespace from the source cluster so a
+// devpod of it can be created in the target cluster, see Options.Source. What
+// only makes sense in the source cluster, like the owner references and the
+// status, is cleared.
+func Import(ctx context.Context, source, target kubernetes.Interface, name, namespace string, opts *Options) (*appsv1.Deployment, error) {
+	dp, err := source.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find deployment %q in namespace %q of the source cluster, cannot import it: %w", name, namespace, err)
+	}
+	warnNewerSource(source, target, opts)
+
+	// The owners don't exist in the target cluster, the garbage collector
+	// would delete the devpod right away.
+	dp.OwnerReferences = nil
+	dp.UID = ""
+	dp.ResourceVersion = ""
+	dp.Generation = 0
+	dp.CreationTimestamp = metav1.Time{}
+	dp.Status = appsv1.DeploymentStatus{}
+	return dp, nil
+}
+
+// warnNewerSource warns when the source cluster runs a newer Kubernetes than
+// the target, fields the target doesn't know about are dropped when the devpod
+// is created there. Nothing is logged if either version can't be found.
+func warnNewerSource(source, target kubernetes.Interface, opts *Options) {
+	sourceInfo, err := source.Discovery().ServerVersion()
+	if err != nil {
+		return
+	}
+	targetInfo, err := target.Discovery().ServerVersion()
+	if err != nil {
+		return
+	}
+	sourceVersion, err := version.ParseGeneric(sourceInfo.GitVersion)
+	if err != nil {
+		return
+	}
+	targetVersion, err := version.ParseGeneric(targetInfo.GitVersion)
+	if err != nil {
+		return
+	}
+	if sourceVersion.Major() > targetVersion.Major() ||
+		(sourceVersion.Major() == targetVersion.Major() && sourceVersion.Minor() > targetVersion.Minor()) {
+		opts.logf("WARNING: The source cluster runs Kubernetes %s and the target %s, fields of the deployment the target doesn't support are dropped\n", sourceInfo.GitVersion, targetInfo.GitVersion)
+	}
+}
@@ -0,0 +1,178 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// installName is the name of the objects created by Install.
+const installName = "devpod"
+
+// Install types, see Install.
+const (
+	InstallJob         = "job"
+	InstallCronJob     = "cronjob"
+	InstallClusterRole = "clusterrole"
+)
+
+// Installation is the RBAC devpod needs to run inside of the cluster.
+type Installation struct {
+	ClusterRole *rbacv1.ClusterRole
+
+	// ServiceAccount and ClusterRoleBinding are nil when only the ClusterRole
+	// is installed.
+	ServiceAccount     *v1.ServiceAccount
+	ClusterRoleBinding *rbacv1.ClusterRoleBinding
+}
+
+// installRules are the permissions devpod needs to create, update and inspect
+// devpods in any namespace. --copy-cluster-roles isn't covered since it would
+// let devpod hand out any clusterrole.
+var installRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps", "secrets"},
+		Verbs:     []string{"get", "create", "update", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"namespaces"},
+		Verbs:     []string{"list"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"serviceaccounts"},
+		Verbs:     []string{"get", "create", "delete"},
+	},
+	// --generate-kubeconfig-for-devpod binds a role with accessRules to the
+	// service account of the devpod, which the API only allows when devpod
+	// holds every permission of the role itself.
+	{
+		APIGroups: []string{"rbac.authorization.k8s.io"},
+		Resources: []string{"roles", "rolebindings"},
+		Verbs:     []string{"get", "create", "update"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods/exec", "pods/portforward"},
+		Verbs:     []string{"create"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods/log"},
+		Verbs:     []string{"get"},
+	},
+	{
+		APIGroups: []string{"node.k8s.io"},
+		Resources: []string{"runtimeclasses"},
+		Verbs:     []string{"get"},
+	},
+	{
+		APIGroups: []string{"policy"},
+		Resources: []string{"poddisruptionbudgets"},
+		Verbs:     []string{"list"},
+	},
+	{
+		APIGroups: []string{"autoscaling"},
+		Resources: []string{"horizontalpodautoscalers"},
+		Verbs:     []string{"list", "create", "update"},
+	},
+	{
+		APIGroups: []string{"metrics.k8s.io"},
+		Resources: []string{"pods"},
+		Verbs:     []string{"get", "list"},
+	},
+}
+
+// NewInstallation builds the RBAC for running devpod as installType. A Job or
+// CronJob runs as the devpod service account in namespace, which is bound to
+// the devpod ClusterRole, while InstallClusterRole only creates the ClusterRole
+// so it can be bound to users or groups by hand.
+func NewInstallation(installType, namespace string) (*Installation, error) {
+	labels := map[string]string{"devpod": "devpod"}
+	install := &Installation{
+		ClusterRole: &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: installName, Labels: labels},
+			Rules:      installRules,
+		},
+	}
+	switch installType {
+	case InstallClusterRole:
+		return install, nil
+	case InstallJob, InstallCronJob:
+	default:
+		return nil, fmt.Errorf("unknown install --type %q, must be one of %s, %s or %s", installType, InstallJob, InstallCronJob, InstallClusterRole)
+	}
+
+	install.ServiceAccount = &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: installName, Namespace: namespace, Labels: labels},
+	}
+	install.ClusterRoleBinding = &rbacv1.ClusterRoleBinding{
+		// ClusterRoleBindings aren't namespaced, so the namespace is part of
+		// the name to allow installing in more than one.
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%s", installName, namespace), Labels: labels},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     installName,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      installName,
+			Namespace: namespace,
+		}},
+	}
+	return install, nil
+}
+
+// Install creates or updates the objects of the installation in the cluster.
+func Install(ctx context.Context, clientset kubernetes.Interface, install *Installation, opts *Options) error {
+	roles := clientset.RbacV1().ClusterRoles()
+	_, err := roles.Create(ctx, install.ClusterRole, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		_, err = roles.Update(ctx, install.ClusterRole, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply clusterrole %q: %w", install.ClusterRole.Name, err)
+	}
+	opts.logf("Applied clusterrole %q.\n", install.ClusterRole.Name)
+
+	if sa := install.ServiceAccount; sa != nil {
+		_, err := clientset.CoreV1().ServiceAccounts(sa.Namespace).Create(ctx, sa, metav1.CreateOptions{})
+		// The service account has nothing to update and updating it would
+		// drop its token secrets on older clusters.
+		if err != nil && !k8serr.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create service account %q in namespace %q: %w", sa.Name, sa.Namespace, err)
+		}
+		opts.logf("Applied service account %s/%s.\n", sa.Namespace, sa.Name)
+	}
+
+	if binding := install.ClusterRoleBinding; binding != nil {
+		if _, err := applyClusterRoleBinding(ctx, clientset, binding); err != nil {
+			return err
+		}
+		opts.logf("Applied clusterrolebinding %q.\n", binding.Name)
+	}
+	return nil
+}
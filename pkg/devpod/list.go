@@ -0,0 +1,49 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// isDevpod reports if the deployment is a devpod, every devpod selects its pods
+// with the devpod label.
+func isDevpod(dp *appsv1.Deployment) bool {
+	return dp.Spec.Selector != nil && dp.Spec.Selector.MatchLabels["devpod"] == "devpod"
+}
+
+// List returns the devpods in namespace, every namespace is searched with
+// metav1.NamespaceAll.
+func List(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]appsv1.Deployment, error) {
+	list, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if namespace == metav1.NamespaceAll {
+			return nil, fmt.Errorf("unable to list deployments in every namespace: %w", err)
+		}
+		return nil, fmt.Errorf("unable to list deployments in namespace %q: %w", namespace, err)
+	}
+	devpods := []appsv1.Deployment{}
+	for _, dp := range list.Items {
+		if isDevpod(&dp) {
+			devpods = append(devpods, dp)
+		}
+	}
+	return devpods, nil
+}
+
+// NamespacesMatching returns the names of the namespaces matching the label
+// selector.
+func NamespacesMatching(ctx context.Context, clientset kubernetes.Interface, selector string) ([]string, error) {
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces matching --set-namespace-label-selector %q: %w", selector, err)
+	}
+	names := make([]string, len(list.Items))
+	for idx, ns := range list.Items {
+		names[idx] = ns.Name
+	}
+	return names, nil
+}
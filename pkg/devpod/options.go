@@ -0,0 +1,192 @@
+package devpod
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fernferret/devpod/pkg/image"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Options holds the settings that change how a devpod is generated from its
+// source resource.
+type Options struct {
+	SkopeoTransport string
+	Force           bool
+	CreateOnly      bool
+	UpdateOnly      bool
+
+	// TargetNamespace creates the devpod and everything it needs in this
+	// namespace instead of the one of the source.
+	TargetNamespace string
+
+	// FromFile reads the source deployment from a local YAML or JSON manifest
+	// instead of the cluster.
+	FromFile string
+
+	// Source is used as the source deployment instead of reading it from the
+	// cluster, e.g. one read from another cluster with Import.
+	Source *appsv1.Deployment
+
+	// NoConfigMap skips the init scripts, the devpod containers only sleep
+	// and no ConfigMap is created.
+	NoConfigMap bool
+
+	// NoWaitForConfigMap creates the Deployment without waiting for the init
+	// ConfigMap to be stored first.
+	NoWaitForConfigMap bool
+
+	// MaxPodCountBeforeWarn warns before creating a devpod in a namespace
+	// with more pods than this, 0 disables the check.
+	MaxPodCountBeforeWarn int
+
+	// Deployment and pod spec overrides
+	ServiceAccount                    string
+	CopyClusterRoles                  bool
+	CopyHPA                           bool
+	CopySecrets                       bool
+	CopyConfigMaps                    bool
+	HPAMinReplicas                    int32
+	HPAMaxReplicas                    int32
+	NodeSelector                      map[string]string
+	NodeAffinity                      []string
+	Tolerations                       []string
+	RuntimeClass                      string
+	MaxHistoryLimit                   int32
+	Strategy                          string
+	MaxSurge                          string
+	MaxUnavailable                    string
+	ProgressDeadline                  time.Duration
+	Paused                            bool
+	NoInitContainers                  bool
+	CopySelectorLabels                bool
+	StripFinalizers                   bool
+	StripTopologySpread               bool
+	StripClusterAutoscalerAnnotations bool
+	StripPodDisruptionBudget          bool
+	KeepManagedFields                 bool
+	OverrideJSON                      string
+	PatchMetadataJSON                 string
+	Patch                             string
+	PatchType                         string
+
+	// Container overrides
+	FieldRefEnv         []string
+	DevtoolsImage       string
+	EnableDebugSidecar  bool
+	DebugImage          string
+	KeepProbes          bool
+	Containers          []string
+	Sidecars            []string
+	Privileged          bool
+	AddCaps             []string
+	AddCapAll           bool
+	DropCaps            []string
+	RunAsRoot           bool
+	StripResourceLimits bool
+	CPULimit            string
+	MemoryLimit         string
+	CPURequest          string
+	MemoryRequest       string
+	AddResourceRequests map[string]string
+
+	// Init scripts
+	GracefulShutdownSignal string
+	ClusterDomain          string
+
+	// Image inspection
+	ImageTagOverride          string
+	ImageRegistryMirrors      map[string]string
+	ImageDigestPin            bool
+	ImageDigestFile           string
+	RefreshDigests            bool
+	ImageInspectParallelLimit int
+	ImageOS                   string
+	ImageArch                 string
+	ImageVariant              string
+	ImageRegistryToken        string
+
+	// Manifest generation
+	GenerateFluxKustomization bool
+	FluxSource                string
+	FluxPath                  string
+
+	GenerateTelepresenceConfig bool
+
+	GenerateHelmValues bool
+	HelmChart          string
+
+	GenerateDevfile bool
+
+	GenerateKindConfig bool
+
+	GeneratePolicyException bool
+
+	GenerateArgoRollout bool
+
+	GenerateConftestPolicy bool
+
+	GenerateOPABundle bool
+	OPAAllowedUsers   []string
+	OPAAllowedGroups  []string
+
+	// GenerateKubeconfig creates the --service-account if needed and a
+	// kubeconfig for it, unlike the other Generate options the devpod is
+	// still applied.
+	GenerateKubeconfig bool
+
+	// Log is where progress messages and warnings are written, nothing is
+	// written if it's nil.
+	Log io.Writer
+
+	// Verbose logs every container of the devpod with its environment. With
+	// SecretEnvMask the values of variables that look like secrets are
+	// masked.
+	Verbose       bool
+	SecretEnvMask bool
+}
+
+func (o *Options) logf(format string, args ...interface{}) {
+	if o.Log != nil {
+		fmt.Fprintf(o.Log, format, args...)
+	}
+}
+
+// inspectOptions returns how the images of the devpod are inspected.
+func (o *Options) inspectOptions() image.InspectOptions {
+	return image.InspectOptions{
+		OS:            o.ImageOS,
+		Architecture:  o.ImageArch,
+		Variant:       o.ImageVariant,
+		RegistryToken: o.ImageRegistryToken,
+	}
+}
+
+// GeneratesManifests reports if any manifest is generated instead of applying
+// the devpod, see GenerateManifests.
+func (o *Options) GeneratesManifests() bool {
+	return o.GenerateFluxKustomization || o.GenerateTelepresenceConfig || o.GenerateHelmValues ||
+		o.GenerateDevfile || o.GenerateKindConfig || o.GeneratePolicyException || o.GenerateArgoRollout || o.GenerateConftestPolicy ||
+		o.GenerateOPABundle
+}
+
+// containsName reports if name is one of names.
+func containsName(name string, names []string) bool {
+	for _, item := range names {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDebugContainer reports if the container will be replaced with a sleeping
+// shell. Containers listed in Sidecars or left out of Containers keep running
+// their original command.
+func (o *Options) IsDebugContainer(name string) bool {
+	if containsName(name, o.Sidecars) {
+		return false
+	}
+	return len(o.Containers) == 0 || containsName(name, o.Containers)
+}
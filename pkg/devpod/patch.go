@@ -0,0 +1,80 @@
+package devpod
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// Patch types for --patch-type, named like kubectl patch --type.
+const (
+	PatchTypeMerge     = "merge"
+	PatchTypeStrategic = "strategic"
+	PatchTypeJSON      = "json"
+)
+
+// patchDeployment applies a JSON or YAML patch of patchType to the devpod dp
+// and returns the patched copy.
+func patchDeployment(dp *appsv1.Deployment, patch, patchType string) (*appsv1.Deployment, error) {
+	patchJSON, err := yaml.YAMLToJSON([]byte(patch))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse --patch: %w", err)
+	}
+	original, err := json.Marshal(dp)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch patchType {
+	case PatchTypeMerge:
+		patched, err = jsonpatch.MergePatch(original, patchJSON)
+	case PatchTypeStrategic:
+		patched, err = strategicpatch.StrategicMergePatch(original, patchJSON, appsv1.Deployment{})
+	case PatchTypeJSON:
+		var ops jsonpatch.Patch
+		ops, err = jsonpatch.DecodePatch(patchJSON)
+		if err == nil {
+			patched, err = ops.Apply(original)
+		}
+	default:
+		return nil, fmt.Errorf("unknown --patch-type %q, must be one of %s, %s or %s", patchType, PatchTypeMerge, PatchTypeStrategic, PatchTypeJSON)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply --patch to devpod %q: %w", dp.Name, err)
+	}
+
+	result := &appsv1.Deployment{}
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, fmt.Errorf("failed to apply --patch to devpod %q: %w", dp.Name, err)
+	}
+	return result, nil
+}
+
+// patchMetadata applies a JSON merge patch to only the metadata of the devpod
+// dp, e.g. to add labels or annotations. The name and namespace can't be
+// changed since the devpod is looked up by them.
+func patchMetadata(dp *appsv1.Deployment, patch string) error {
+	original, err := json.Marshal(dp.ObjectMeta)
+	if err != nil {
+		return err
+	}
+	patched, err := jsonpatch.MergePatch(original, []byte(patch))
+	if err != nil {
+		return fmt.Errorf("failed to apply --patch-metadata-json to devpod %q: %w", dp.Name, err)
+	}
+	meta := metav1.ObjectMeta{}
+	if err := json.Unmarshal(patched, &meta); err != nil {
+		return fmt.Errorf("failed to apply --patch-metadata-json to devpod %q: %w", dp.Name, err)
+	}
+	if meta.Name != dp.Name || meta.Namespace != dp.Namespace {
+		return fmt.Errorf("--patch-metadata-json can't change the name or namespace of devpod %q", dp.Name)
+	}
+	dp.ObjectMeta = meta
+	return nil
+}
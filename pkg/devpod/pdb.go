@@ -0,0 +1,86 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// matchingPodDisruptionBudgets returns the PodDisruptionBudgets in the
+// namespace of the devpod dp that select its pods.
+func matchingPodDisruptionBudgets(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment) ([]policyv1.PodDisruptionBudget, error) {
+	list, err := clientset.PolicyV1().PodDisruptionBudgets(dp.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	podLabels := labels.Set(dp.Spec.Template.Labels)
+	matching := []policyv1.PodDisruptionBudget{}
+	for _, pdb := range list.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(podLabels) {
+			matching = append(matching, pdb)
+		}
+	}
+	return matching, nil
+}
+
+// stripPodDisruptionBudget adds a -devpod suffix to the pod labels of the devpod
+// dp that the PodDisruptionBudget selects on, along with the same labels of the
+// deployment selector. Selectors that only check a label exists can still
+// match afterwards.
+func stripPodDisruptionBudget(dp *appsv1.Deployment, pdb *policyv1.PodDisruptionBudget) {
+	keys := []string{}
+	for key := range pdb.Spec.Selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	for _, expr := range pdb.Spec.Selector.MatchExpressions {
+		keys = append(keys, expr.Key)
+	}
+	for _, key := range keys {
+		val, ok := dp.Spec.Template.Labels[key]
+		if !ok || strings.HasSuffix(val, "-devpod") {
+			continue
+		}
+		dp.Spec.Template.Labels[key] = fmt.Sprintf("%s-devpod", val)
+		if _, ok := dp.Spec.Selector.MatchLabels[key]; ok {
+			dp.Spec.Selector.MatchLabels[key] = dp.Spec.Template.Labels[key]
+		}
+	}
+}
+
+// checkPodDisruptionBudgets warns about the PodDisruptionBudgets that select
+// the pods of the devpod dp, they would keep it from being evicted and count
+// it towards the budget of the source. With StripPodDisruptionBudget the
+// labels they select on are changed instead.
+func checkPodDisruptionBudgets(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment, opts *Options) error {
+	matching, err := matchingPodDisruptionBudgets(ctx, clientset, dp)
+	if err != nil {
+		// Only the warning is lost, unless the labels had to be changed.
+		if opts.StripPodDisruptionBudget {
+			return fmt.Errorf("unable to list poddisruptionbudgets in namespace %q for --strip-pod-disruption-budget: %w", dp.Namespace, err)
+		}
+		return nil
+	}
+	for idx := range matching {
+		pdb := &matching[idx]
+		if !opts.StripPodDisruptionBudget {
+			opts.logf("WARNING: Poddisruptionbudget %s/%s selects the devpod pods, use --strip-pod-disruption-budget to change the labels it selects on\n", pdb.Namespace, pdb.Name)
+			continue
+		}
+		stripPodDisruptionBudget(dp, pdb)
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err == nil && selector.Matches(labels.Set(dp.Spec.Template.Labels)) {
+			opts.logf("WARNING: Poddisruptionbudget %s/%s still selects the devpod pods, its selector doesn't depend on the label values\n", pdb.Namespace, pdb.Name)
+		}
+	}
+	return nil
+}
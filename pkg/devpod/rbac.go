@@ -0,0 +1,82 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// serviceAccountName returns the service account pods of the spec run as, an
+// empty name means the namespace default.
+func serviceAccountName(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// copyClusterRoleBindings finds the ClusterRoleBindings that grant the source
+// service account in sourceNamespace a ClusterRole and returns copies binding
+// the service account of the devpod dp to the same roles, so --service-account
+// or --namespace-target don't cost the devpod its cluster wide permissions.
+func copyClusterRoleBindings(ctx context.Context, clientset kubernetes.Interface, sourceServiceAccount, sourceNamespace string, dp *appsv1.Deployment) ([]*rbacv1.ClusterRoleBinding, error) {
+	namespace := dp.Namespace
+	serviceAccount := serviceAccountName(dp.Spec.Template.Spec.ServiceAccountName)
+	sourceServiceAccount = serviceAccountName(sourceServiceAccount)
+	if serviceAccount == sourceServiceAccount && namespace == sourceNamespace {
+		return nil, nil
+	}
+
+	bindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list clusterrolebindings for --copy-cluster-roles: %w", err)
+	}
+
+	copies := []*rbacv1.ClusterRoleBinding{}
+	for _, binding := range bindings.Items {
+		found := false
+		for _, subject := range binding.Subjects {
+			found = found || (subject.Kind == rbacv1.ServiceAccountKind && subject.Name == sourceServiceAccount && subject.Namespace == sourceNamespace)
+		}
+		if !found {
+			continue
+		}
+		copies = append(copies, &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				// ClusterRoleBindings aren't namespaced, so the namespace is
+				// part of the name to keep devpods from clashing.
+				Name:        fmt.Sprintf("%s-%s-%s", binding.Name, namespace, dp.Name),
+				Labels:      map[string]string{"devpod": "devpod"},
+				Annotations: map[string]string{"devpod": "Created by devpod"},
+			},
+			RoleRef: binding.RoleRef,
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccount,
+				Namespace: namespace,
+			}},
+		})
+	}
+	return copies, nil
+}
+
+// applyClusterRoleBinding creates the binding or updates it if it already
+// exists, it reports if the object is new.
+func applyClusterRoleBinding(ctx context.Context, clientset kubernetes.Interface, binding *rbacv1.ClusterRoleBinding) (bool, error) {
+	client := clientset.RbacV1().ClusterRoleBindings()
+	created := true
+	_, err := client.Create(ctx, binding, metav1.CreateOptions{})
+	if k8serr.IsAlreadyExists(err) {
+		created = false
+		_, err = client.Update(ctx, binding, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to apply clusterrolebinding %q: %w", binding.Name, err)
+	}
+	return created, nil
+}
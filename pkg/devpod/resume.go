@@ -0,0 +1,21 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Resume unpauses a devpod that was created paused, the same as running
+// kubectl rollout resume.
+func Resume(ctx context.Context, clientset kubernetes.Interface, name, namespace string) error {
+	patch := []byte(`{"spec":{"paused":false}}`)
+	_, err := clientset.AppsV1().Deployments(namespace).Patch(ctx, name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to resume devpod %q in namespace %q: %w", name, namespace, err)
+	}
+	return nil
+}
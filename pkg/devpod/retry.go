@@ -0,0 +1,41 @@
+package devpod
+
+import (
+	"context"
+	"time"
+
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// apiBackoff allows 3 retries of a mutating API call, waiting about 200ms,
+// 400ms and 800ms in between.
+var apiBackoff = wait.Backoff{
+	Steps:    4,
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// retriable reports if err is a conflict or a transient server error that the
+// call is likely to get past when it's made again.
+func retriable(err error) bool {
+	return k8serr.IsConflict(err) ||
+		k8serr.IsTooManyRequests(err) ||
+		k8serr.IsInternalError(err) ||
+		k8serr.IsServerTimeout(err) ||
+		k8serr.IsServiceUnavailable(err) ||
+		k8serr.IsTimeout(err) ||
+		k8serr.IsUnexpectedServerError(err)
+}
+
+// withRetry calls fn until it succeeds, fails with an error that isn't
+// retriable or apiBackoff runs out, the same way retry.RetryOnConflict does
+// for conflicts. fn must get anything it updates again so a conflict isn't
+// retried with the same stale object.
+func withRetry(ctx context.Context, fn func() error) error {
+	return retry.OnError(apiBackoff, func(err error) bool {
+		return ctx.Err() == nil && retriable(err)
+	}, fn)
+}
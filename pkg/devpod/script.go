@@ -0,0 +1,190 @@
+package devpod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/fernferret/devpod/pkg/image"
+	v1 "k8s.io/api/core/v1"
+)
+
+// safeShellWord matches words that don't need any quoting in sh.
+var safeShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s so sh treats it as a single word. Words that are already
+// safe are left alone to keep the scripts readable, everything else is single
+// quoted with any embedded single quotes escaped.
+func shellQuote(s string) string {
+	if safeShellWord.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes every arg and joins them into a single command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for idx, arg := range args {
+		quoted[idx] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellComment keeps multi-line values inside of a comment, otherwise anything
+// after the first newline would be run.
+func shellComment(s string) string {
+	return strings.ReplaceAll(s, "\n", "\n# ")
+}
+
+var initScriptTemplate = template.Must(template.New("init").Funcs(template.FuncMap{
+	"shellQuote":   shellQuote,
+	"shellJoin":    shellJoin,
+	"shellComment": shellComment,
+}).Parse(`#!/bin/sh
+{{ with .TrapSignal }}
+# The trap is handled by the shell running this script. The kubelet only
+# signals PID 1 of the container, which forwards {{ . }} to every process
+# started with kubectl exec, like this script and the commands it runs.
+trap 'echo "Received {{ . }}, shutting down..."' {{ . }}
+{{ end }}
+{{- with .ServiceDomain }}
+# Services in the namespace resolve as <service>.$DEVPOD_SERVICE_DOMAIN
+export DEVPOD_SERVICE_DOMAIN={{ shellQuote . }}
+{{ end }}
+{{- with .WorkingDir }}
+echo {{ printf "Setting WorkingDir via: cd %s" . | shellQuote }}
+cd {{ shellQuote . }}
+{{ end }}
+{{- with .ContainerCommand }}
+# Command (ENTRYPOINT) from container:
+# {{ shellJoin . | shellComment }}
+{{- end }}
+{{- with .ImageEntrypoint }}
+# Command (ENTRYPOINT) from image:
+# {{ shellJoin . | shellComment }}
+{{- end }}
+{{- with .ContainerArgs }}
+# Args (CMD) from container:
+# {{ shellJoin . | shellComment }}
+{{- end }}
+{{- with .ImageCmd }}
+# Args (CMD) from image:
+# {{ shellJoin . | shellComment }}
+{{- end }}
+
+{{ shellJoin .Line }}
+`))
+
+// initScriptData is what's passed to initScriptTemplate.
+type initScriptData struct {
+	TrapSignal       string
+	ServiceDomain    string
+	WorkingDir       string
+	ContainerCommand []string
+	ContainerArgs    []string
+	ImageEntrypoint  []string
+	ImageCmd         []string
+
+	// Line is the command that would have been run by the container.
+	Line []string
+}
+
+// resolveCommand returns the command line a container runs, following the
+// Kubernetes rules for combining a container's command and args with the
+// image's ENTRYPOINT and CMD:
+//
+//   - no command or args: the image ENTRYPOINT and CMD are used
+//   - a command but no args: only the command is used, the image CMD is
+//     ignored along with the ENTRYPOINT
+//   - args but no command: the image ENTRYPOINT is run with the args
+//   - both a command and args: the image is ignored entirely
+func resolveCommand(command, args, entrypoint, cmd []string) []string {
+	var line []string
+	if len(command) > 0 {
+		line = append(line, command...)
+		return append(line, args...)
+	}
+	line = append(line, entrypoint...)
+	if len(args) > 0 {
+		return append(line, args...)
+	}
+	return append(line, cmd...)
+}
+
+// initScriptName is the key of the init script for the container at idx in the
+// devpod ConfigMap.
+func initScriptName(idx int, container string) string {
+	return fmt.Sprintf("%d_%s.sh", idx, container)
+}
+
+// SaveScripts writes the init scripts of the devpod ConfigMap cm to dir, it's
+// created if it doesn't exist.
+func SaveScripts(cm *v1.ConfigMap, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for filename, script := range cm.Data {
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(script), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signalName matches signal names as sh's trap builtin expects them.
+var signalName = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+// trapSignal normalizes a signal like "SIGTERM" or "term" into the "TERM" form
+// that every sh accepts.
+func trapSignal(signal string) (string, error) {
+	signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+	if !signalName.MatchString(signal) {
+		return "", fmt.Errorf("invalid --graceful-shutdown-hook signal %q", signal)
+	}
+	return signal, nil
+}
+
+// forwardSignalScript sleeps forever like "sleep infinity" but handles signal.
+// The kubelet only signals PID 1 of a container when the pod is stopped, which
+// is what this runs as, so the signal is forwarded to every other process in
+// the container, i.e. whatever was started with kubectl exec. The container
+// exits once they're all gone or when the grace period is over. The sleep is
+// waited for in the background, sh doesn't run traps while a foreground
+// command is running.
+func forwardSignalScript(signal string) string {
+	return fmt.Sprintf(`trap 'echo "Received %[1]s, forwarding it to the processes started with kubectl exec..."; kill -s %[1]s -- -1; while kill -s 0 -- -1 2>/dev/null; do sleep 1; done; exit 0' %[1]s
+sleep infinity &
+wait`, signal)
+}
+
+// renderInitScript generates the script that runs the original command of the
+// container. The image details may be nil if the image couldn't be inspected.
+// If signal isn't empty a trap for it is added to the top of the script, so
+// in-flight work gets a chance to finish when the pod is replaced. The
+// serviceDomain, e.g. default.svc.cluster.local, is exported so the command
+// can build the DNS names of services.
+func renderInitScript(item v1.Container, imageDetails *image.Info, signal, serviceDomain string) (string, error) {
+	if imageDetails == nil {
+		imageDetails = &image.Info{}
+	}
+	data := initScriptData{
+		TrapSignal:       signal,
+		ServiceDomain:    serviceDomain,
+		WorkingDir:       item.WorkingDir,
+		ContainerCommand: item.Command,
+		ContainerArgs:    item.Args,
+		ImageEntrypoint:  imageDetails.Entrypoint,
+		ImageCmd:         imageDetails.Cmd,
+		Line:             resolveCommand(item.Command, item.Args, imageDetails.Entrypoint, imageDetails.Cmd),
+	}
+
+	var script strings.Builder
+	if err := initScriptTemplate.Execute(&script, data); err != nil {
+		return "", err
+	}
+	return script.String(), nil
+}
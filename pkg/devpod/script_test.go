@@ -0,0 +1,145 @@
+package devpod
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fernferret/devpod/pkg/image"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	testCommand    = []string{"/app/server"}
+	testArgs       = []string{"--listen", ":8080"}
+	testEntrypoint = []string{"/docker-entrypoint.sh"}
+	testCmd        = []string{"serve", "--config", "/etc/app.yaml"}
+)
+
+// commandCases covers every combination of a container command, container
+// args, image ENTRYPOINT and image CMD being set.
+var commandCases = []struct {
+	name                           string
+	command, args, entrypoint, cmd []string
+	want                           []string
+}{
+	{name: "nothing set"},
+	{name: "image cmd", cmd: testCmd, want: testCmd},
+	{name: "image entrypoint", entrypoint: testEntrypoint, want: testEntrypoint},
+	{name: "image entrypoint and cmd", entrypoint: testEntrypoint, cmd: testCmd, want: []string{"/docker-entrypoint.sh", "serve", "--config", "/etc/app.yaml"}},
+	{name: "args", args: testArgs, want: testArgs},
+	{name: "args override image cmd", args: testArgs, cmd: testCmd, want: testArgs},
+	{name: "args with image entrypoint", args: testArgs, entrypoint: testEntrypoint, want: []string{"/docker-entrypoint.sh", "--listen", ":8080"}},
+	{name: "args with image entrypoint and cmd", args: testArgs, entrypoint: testEntrypoint, cmd: testCmd, want: []string{"/docker-entrypoint.sh", "--listen", ":8080"}},
+	{name: "command", command: testCommand, want: testCommand},
+	{name: "command ignores image cmd", command: testCommand, cmd: testCmd, want: testCommand},
+	{name: "command ignores image entrypoint", command: testCommand, entrypoint: testEntrypoint, want: testCommand},
+	{name: "command ignores image entrypoint and cmd", command: testCommand, entrypoint: testEntrypoint, cmd: testCmd, want: testCommand},
+	{name: "command and args", command: testCommand, args: testArgs, want: []string{"/app/server", "--listen", ":8080"}},
+	{name: "command and args ignore image cmd", command: testCommand, args: testArgs, cmd: testCmd, want: []string{"/app/server", "--listen", ":8080"}},
+	{name: "command and args ignore image entrypoint", command: testCommand, args: testArgs, entrypoint: testEntrypoint, want: []string{"/app/server", "--listen", ":8080"}},
+	{name: "command and args ignore image", command: testCommand, args: testArgs, entrypoint: testEntrypoint, cmd: testCmd, want: []string{"/app/server", "--listen", ":8080"}},
+}
+
+func TestResolveCommand(t *testing.T) {
+	for _, tc := range commandCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := resolveCommand(tc.command, tc.args, tc.entrypoint, tc.cmd)
+			if len(got) == 0 && len(tc.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("resolveCommand() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// lastLine returns the last non-empty line of script.
+func lastLine(script string) string {
+	lines := strings.Split(strings.TrimRight(script, "\n"), "\n")
+	return lines[len(lines)-1]
+}
+
+func TestRenderInitScriptCommandLine(t *testing.T) {
+	for _, tc := range commandCases {
+		t.Run(tc.name, func(t *testing.T) {
+			item := v1.Container{Name: "app", Command: tc.command, Args: tc.args}
+			info := &image.Info{Entrypoint: tc.entrypoint, Cmd: tc.cmd}
+			script, err := renderInitScript(item, info, "", "")
+			if err != nil {
+				t.Fatalf("renderInitScript() error = %v", err)
+			}
+			if !strings.HasPrefix(script, "#!/bin/sh\n") {
+				t.Errorf("script doesn't start with a shebang:\n%s", script)
+			}
+			want := shellJoin(tc.want)
+			if want == "" {
+				want = "#!/bin/sh"
+			}
+			if got := lastLine(script); got != want {
+				t.Errorf("command line = %q, want %q\n%s", got, want, script)
+			}
+		})
+	}
+}
+
+func TestRenderInitScript(t *testing.T) {
+	item := v1.Container{
+		Name:       "app",
+		WorkingDir: "/srv/my app",
+		Args:       []string{"--name", "it's"},
+	}
+	info := &image.Info{Entrypoint: []string{"/entry.sh"}, Cmd: []string{"ignored"}}
+	script, err := renderInitScript(item, info, "TERM", "default.svc.cluster.local")
+	if err != nil {
+		t.Fatalf("renderInitScript() error = %v", err)
+	}
+	want := `#!/bin/sh
+
+# The trap is handled by the shell running this script. The kubelet only
+# signals PID 1 of the container, which forwards TERM to every process
+# started with kubectl exec, like this script and the commands it runs.
+trap 'echo "Received TERM, shutting down..."' TERM
+
+# Services in the namespace resolve as <service>.$DEVPOD_SERVICE_DOMAIN
+export DEVPOD_SERVICE_DOMAIN=default.svc.cluster.local
+
+echo 'Setting WorkingDir via: cd /srv/my app'
+cd '/srv/my app'
+
+# Command (ENTRYPOINT) from image:
+# /entry.sh
+# Args (CMD) from container:
+# --name 'it'\''s'
+# Args (CMD) from image:
+# ignored
+
+/entry.sh --name 'it'\''s'
+`
+	if script != want {
+		t.Errorf("renderInitScript() =\n%s\nwant:\n%s", script, want)
+	}
+}
+
+func TestRenderInitScriptWithoutImage(t *testing.T) {
+	item := v1.Container{Name: "app", Args: []string{"run"}}
+	script, err := renderInitScript(item, nil, "", "")
+	if err != nil {
+		t.Fatalf("renderInitScript() error = %v", err)
+	}
+	if got := lastLine(script); got != "run" {
+		t.Errorf("command line = %q, want %q", got, "run")
+	}
+}
+
+func TestRenderInitScriptMultilineComment(t *testing.T) {
+	item := v1.Container{Name: "app", Command: []string{"sh", "-c", "echo one\necho two"}}
+	script, err := renderInitScript(item, nil, "", "")
+	if err != nil {
+		t.Fatalf("renderInitScript() error = %v", err)
+	}
+	if !strings.Contains(script, "# sh -c 'echo one\n# echo two'\n") {
+		t.Errorf("multi-line command isn't commented out:\n%s", script)
+	}
+}
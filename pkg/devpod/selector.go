@@ -0,0 +1,33 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FindDeployment returns the name of the only deployment in namespace matching
+// the label selector, it's an error if none or more than one match. Devpods
+// keep the labels of their source, so they're never matched.
+func FindDeployment(ctx context.Context, clientset kubernetes.Interface, selector, namespace string) (string, error) {
+	list, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", fmt.Errorf("unable to list deployments matching --label-selector %q in namespace %q: %w", selector, namespace, err)
+	}
+	names := []string{}
+	for _, item := range list.Items {
+		if !isDevpod(&item) {
+			names = append(names, item.Name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no deployments match --label-selector %q in namespace %q", selector, namespace)
+	case 1:
+		return names[0], nil
+	}
+	return "", fmt.Errorf("%d deployments match --label-selector %q in namespace %q, only one is allowed: %s", len(names), selector, namespace, strings.Join(names, ", "))
+}
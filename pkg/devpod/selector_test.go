@@ -0,0 +1,60 @@
+package devpod
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// namedDeployment returns a copy of the source deployment called name.
+func namedDeployment(name string) *appsv1.Deployment {
+	dp := sourceDeployment()
+	dp.Name = name
+	return dp
+}
+
+func TestFindDeployment(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	got, err := FindDeployment(context.Background(), clientset, "app=api", "default")
+	if err != nil {
+		t.Fatalf("FindDeployment() error = %v", err)
+	}
+	if got != "api" {
+		t.Errorf("FindDeployment() = %q, want api", got)
+	}
+}
+
+func TestFindDeploymentSkipsDevpods(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	buildAndApply(t, clientset, testOptions(t))
+
+	got, err := FindDeployment(context.Background(), clientset, "app=api", "default")
+	if err != nil {
+		t.Fatalf("FindDeployment() error = %v", err)
+	}
+	if got != "api" {
+		t.Errorf("FindDeployment() = %q, want api", got)
+	}
+}
+
+func TestFindDeploymentNoMatch(t *testing.T) {
+	clientset := newClientset(sourceDeployment())
+	if _, err := FindDeployment(context.Background(), clientset, "app=web", "default"); err == nil {
+		t.Error("FindDeployment() didn't fail when nothing matches")
+	}
+}
+
+func TestFindDeploymentMultiple(t *testing.T) {
+	clientset := newClientset(namedDeployment("api-blue"), namedDeployment("api-green"))
+	_, err := FindDeployment(context.Background(), clientset, "app=api", "default")
+	if err == nil {
+		t.Fatal("FindDeployment() didn't fail when more than one deployment matches")
+	}
+	for _, name := range []string{"api-blue", "api-green"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q doesn't list %s", err, name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Status is a snapshot of a devpod in the cluster.
+type Status struct {
+	Deployment *appsv1.Deployment
+	Pods       []PodStatus
+
+	// ConfigMap holds the init scripts, it's nil if it doesn't exist.
+	ConfigMap *v1.ConfigMap
+}
+
+// PodStatus is a pod of the devpod along with its events, oldest first.
+type PodStatus struct {
+	Pod    v1.Pod
+	Events []v1.Event
+}
+
+// Pods returns the pods created for the devpod deployment.
+func Pods(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment) ([]v1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(dp.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(dp.Spec.Selector),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// GetStatus fetches the devpod deployment, its pods and their events, and the
+// init scripts.
+func GetStatus(ctx context.Context, clientset kubernetes.Interface, name, namespace string) (*Status, error) {
+	dp, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find devpod %q in namespace %q: %w", name, namespace, err)
+	}
+	status := &Status{Deployment: dp}
+
+	pods, err := Pods(ctx, clientset, dp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods for devpod %q in namespace %q: %w", name, namespace, err)
+	}
+	for _, pod := range pods {
+		events, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.Set{
+				"involvedObject.kind": "Pod",
+				"involvedObject.name": pod.Name,
+			}.String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events for pod %q in namespace %q: %w", pod.Name, namespace, err)
+		}
+		sort.Slice(events.Items, func(i, j int) bool {
+			return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+		})
+		status.Pods = append(status.Pods, PodStatus{Pod: pod, Events: events.Items})
+	}
+
+	cmName := fmt.Sprintf("%s-init", dp.Name)
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, cmName, metav1.GetOptions{})
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get configmap %q in namespace %q: %w", cmName, namespace, err)
+		}
+		return status, nil
+	}
+	status.ConfigMap = cm
+	return status, nil
+}
@@ -0,0 +1,27 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// PodMetrics returns the current CPU and memory usage of the devpod pods from
+// the Metrics API, which requires metrics-server (or similar) in the cluster.
+func PodMetrics(ctx context.Context, clientset kubernetes.Interface, metricsClient metrics.Interface, name, namespace string) ([]metricsv1beta1.PodMetrics, error) {
+	dp, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find devpod %q in namespace %q: %w", name, namespace, err)
+	}
+	podMetrics, err := metricsClient.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(dp.Spec.Selector),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics for devpod %q in namespace %q: %w", name, namespace, err)
+	}
+	return podMetrics.Items, nil
+}
@@ -0,0 +1,21 @@
+package devpod
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans around the slow parts of building and applying a
+// devpod, they're only recorded once a tracer provider is registered with
+// otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/fernferret/devpod/pkg/devpod")
+
+// endSpan ends span, marking it failed with err if there is one.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,186 @@
+package devpod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// waitInterval is how often the devpod is checked while waiting for it.
+const waitInterval = time.Second
+
+// rolledOut reports if every replica of the deployment is running the latest
+// pod template, so no pods of an older devpod are left.
+func rolledOut(dp *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dp.Spec.Replicas != nil {
+		replicas = *dp.Spec.Replicas
+	}
+	return dp.Status.ObservedGeneration >= dp.Generation &&
+		dp.Status.UpdatedReplicas >= replicas &&
+		dp.Status.Replicas <= dp.Status.UpdatedReplicas &&
+		dp.Status.AvailableReplicas >= dp.Status.UpdatedReplicas
+}
+
+// RunningPod returns a running pod of the devpod dp, or nil if there isn't one.
+func RunningPod(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment) (*v1.Pod, error) {
+	pods, err := Pods(ctx, clientset, dp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods for devpod %q in namespace %q: %w", dp.Name, dp.Namespace, err)
+	}
+	for idx := range pods {
+		pod := &pods[idx]
+		if pod.Status.Phase == v1.PodRunning && pod.DeletionTimestamp == nil {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+// WaitForPod waits until the devpod dp has rolled out and returns its running
+// pod, or until ctx is done.
+func WaitForPod(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment) (*v1.Pod, error) {
+	if dp.Spec.Paused {
+		return nil, fmt.Errorf("devpod %q in namespace %q is paused and won't start until it's resumed", dp.Name, dp.Namespace)
+	}
+	var pod *v1.Pod
+	err := wait.PollImmediateUntilWithContext(ctx, waitInterval, func(ctx context.Context) (bool, error) {
+		current, err := clientset.AppsV1().Deployments(dp.Namespace).Get(ctx, dp.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("unable to find devpod %q in namespace %q: %w", dp.Name, dp.Namespace, err)
+		}
+		if !rolledOut(current) {
+			return false, nil
+		}
+		pod, err = RunningPod(ctx, clientset, current)
+		return pod != nil, err
+	})
+	return pod, err
+}
+
+// StreamEvents writes the events of the pods of the devpod dp to out as they
+// happen until ctx is done, e.g. image pulls, scheduling failures or OOM kills.
+// The pods are listed every waitInterval so the pods of a new rollout are
+// picked up too.
+func StreamEvents(ctx context.Context, clientset kubernetes.Interface, dp *appsv1.Deployment, out io.Writer) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	watching := map[string]bool{}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		// Failing to list the pods is reported by whatever is waiting for
+		// them, the next interval tries again.
+		pods, err := Pods(ctx, clientset, dp)
+		if err != nil {
+			return
+		}
+		for _, pod := range pods {
+			if watching[pod.Name] {
+				continue
+			}
+			watching[pod.Name] = true
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				watchPodEvents(ctx, clientset, name, dp.Namespace, &mu, out)
+			}(pod.Name)
+		}
+	}, waitInterval)
+}
+
+// watchPodEvents writes the events of the pod to out until ctx is done or the
+// watch is closed, mu is held while writing.
+func watchPodEvents(ctx context.Context, clientset kubernetes.Interface, name, namespace string, mu *sync.Mutex, out io.Writer) {
+	watcher, err := clientset.CoreV1().Events(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.name=%s", name),
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			mu.Lock()
+			fmt.Fprintf(out, "WARNING: Unable to watch the events of pod %q in namespace %q: %s\n", name, namespace, err)
+			mu.Unlock()
+		}
+		return
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			event, ok := item.Object.(*v1.Event)
+			if !ok || (item.Type != watch.Added && item.Type != watch.Modified) {
+				continue
+			}
+			// Events recorded with the events.k8s.io API only set the
+			// EventTime.
+			timestamp := event.LastTimestamp.Time
+			if timestamp.IsZero() {
+				timestamp = event.EventTime.Time
+			}
+			mu.Lock()
+			fmt.Fprintf(out, "  %s %s %s %s: %s\n", timestamp.Format("15:04:05"), name, event.Type, event.Reason, event.Message)
+			mu.Unlock()
+		}
+	}
+}
+
+// WatchSource calls changed with the deployment name in namespace every time
+// its spec changes, until ctx is done or changed returns an error. Only a new
+// generation counts as a change so status and metadata updates are ignored.
+func WatchSource(ctx context.Context, clientset kubernetes.Interface, name, namespace string, changed func(*appsv1.Deployment) error) error {
+	client := clientset.AppsV1().Deployments(namespace)
+	source, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to find deployment %q in namespace %q to watch: %w", name, namespace, err)
+	}
+	generation, resourceVersion := source.Generation, source.ResourceVersion
+	for ctx.Err() == nil {
+		watcher, err := client.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   "metadata.name=" + name,
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to watch deployment %q in namespace %q: %w", name, namespace, err)
+		}
+		for event := range watcher.ResultChan() {
+			switch event.Type {
+			case watch.Deleted:
+				watcher.Stop()
+				return fmt.Errorf("deployment %q in namespace %q was deleted", name, namespace)
+			case watch.Error:
+				// The resource version expired, watch again from the
+				// current one.
+				resourceVersion = ""
+				continue
+			}
+			current, ok := event.Object.(*appsv1.Deployment)
+			if !ok || current.Name != name {
+				continue
+			}
+			resourceVersion = current.ResourceVersion
+			if current.Generation == generation {
+				continue
+			}
+			generation = current.Generation
+			if err := changed(current); err != nil {
+				watcher.Stop()
+				return err
+			}
+		}
+		watcher.Stop()
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,124 @@
+package image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// DigestCache maps image references as they appear in the pod spec to what was
+// found when inspecting them, see CacheKey. The config is kept next to the
+// digest so cached images don't need to hit the registry at all.
+type DigestCache map[string]*Info
+
+// CacheKey returns the key of image in a DigestCache. The digest of a manifest
+// list is the same for every platform, but the manifest and config picked from
+// it aren't, so the platform is part of the key. The default platform, linux on
+// the architecture devpod runs on, keeps the plain image as its key, which is
+// what digest files written before the platform was part of the key use.
+func (o InspectOptions) CacheKey(image string) string {
+	if (o.OS == "" || o.OS == "linux") && o.Architecture == "" && o.Variant == "" {
+		return image
+	}
+	return fmt.Sprintf("%s %s/%s/%s", image, o.OS, o.Architecture, o.Variant)
+}
+
+// LoadDigestCache reads the cache from path, an empty path or a missing file
+// results in an empty cache.
+func LoadDigestCache(path string) (DigestCache, error) {
+	cache := DigestCache{}
+	if path == "" {
+		return cache, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// SaveDigestCache writes the cache to path, nothing is written if path is
+// empty.
+func SaveDigestCache(path string, cache DigestCache) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Pin replaces the tag of image with the digest dgst, images that are
+// already referenced by digest are returned as is.
+func Pin(image string, dgst digest.Digest) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		return image, nil
+	}
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(named), dgst)
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(pinned), nil
+}
+
+// Retag replaces the tag of image with tag. A digest is dropped as well since
+// it pins the content of the old tag.
+func Retag(image, tag string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	tagged, err := reference.WithTag(reference.TrimNamed(named), tag)
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(tagged), nil
+}
+
+// Mirror rewrites the registry part of image with the longest matching source
+// in mirrors, e.g. {"docker.io": "registry.internal"} turns nginx:1.25 into
+// registry.internal/library/nginx:1.25. A source matches whole path components
+// of the normalized name, the tag and digest are kept. The image is returned as
+// is if no source matches.
+func Mirror(image string, mirrors map[string]string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	name := named.Name()
+	match, dst := "", ""
+	for src, mirror := range mirrors {
+		src = strings.TrimSuffix(src, "/")
+		if (name == src || strings.HasPrefix(name, src+"/")) && len(src) > len(match) {
+			match, dst = src, strings.TrimSuffix(mirror, "/")
+		}
+	}
+	if match == "" {
+		return image, nil
+	}
+	mirrored, err := reference.ParseNormalizedNamed(dst + named.String()[len(match):])
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(mirrored), nil
+}
@@ -0,0 +1,28 @@
+package image
+
+import "testing"
+
+func TestCacheKey(t *testing.T) {
+	keys := map[string]bool{}
+	for _, opts := range []InspectOptions{
+		{},
+		{OS: "windows"},
+		{Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "linux", Architecture: "amd64"},
+	} {
+		key := opts.CacheKey("nginx:1.25")
+		if keys[key] {
+			t.Errorf("CacheKey() = %q for %+v, another platform has the same key", key, opts)
+		}
+		keys[key] = true
+	}
+	// Digest files written before the platform was part of the key still
+	// work for the default platform, the CLI defaults to --os linux.
+	for _, opts := range []InspectOptions{{RegistryToken: "token"}, {OS: "linux"}} {
+		if got := opts.CacheKey("nginx:1.25"); got != "nginx:1.25" {
+			t.Errorf("CacheKey() = %q for %+v, want nginx:1.25", got, opts)
+		}
+	}
+}
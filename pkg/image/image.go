@@ -0,0 +1,110 @@
+// Package image looks up the configuration of container images so devpods can
+// reproduce the command an image would have run.
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Info is the part of an image config devpod cares about.
+type Info struct {
+	Cmd        []string      `json:"cmd,omitempty"`
+	Entrypoint []string      `json:"entrypoint,omitempty"`
+	WorkingDir string        `json:"workingDir,omitempty"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+}
+
+// InspectOptions change how an image is inspected. The platform fields pick
+// the image to inspect from a manifest list, an empty one uses the one of the
+// machine devpod runs on.
+type InspectOptions struct {
+	OS           string
+	Architecture string
+	Variant      string
+
+	// RegistryToken is sent as the bearer token to the registry instead of
+	// the credentials in the auth file, e.g. ~/.docker/config.json.
+	RegistryToken string
+}
+
+func parseImageSource(ctx context.Context, sys *types.SystemContext, name string) (types.ImageSource, error) {
+	ref, err := alltransports.ParseImageName(name)
+	if err != nil {
+		return nil, err
+	}
+	return ref.NewImageSource(ctx, sys)
+}
+
+// dockerTransport is the transport of images in a registry.
+const dockerTransport = "docker://"
+
+// TransportName joins the transport and the image reference for Inspect. For
+// the docker transport the reference is normalized first, a tag is dropped when
+// there's a digest as well, e.g. app:1.2@sha256:..., since the transport can't
+// parse both and the digest is what a kubelet pulls anyway.
+func TransportName(transport, imageName string) (string, error) {
+	if transport != dockerTransport {
+		return transport + imageName, nil
+	}
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %w", imageName, err)
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		if _, ok := named.(reference.Tagged); ok {
+			named, err = reference.WithDigest(reference.TrimNamed(named), canonical.Digest())
+			if err != nil {
+				return "", fmt.Errorf("invalid image reference %q: %w", imageName, err)
+			}
+		}
+	}
+	return transport + named.String(), nil
+}
+
+// Inspect fetches the manifest and config of the image, the name must include
+// the transport, e.g. docker://alpine:latest, see TransportName. For a manifest
+// list the image of the platform in opts is inspected.
+func Inspect(ctx context.Context, imageName string, opts InspectOptions) (*Info, error) {
+	sys := &types.SystemContext{
+		OSChoice:                  opts.OS,
+		ArchitectureChoice:        opts.Architecture,
+		VariantChoice:             opts.Variant,
+		DockerBearerRegistryToken: opts.RegistryToken,
+	}
+	src, err := parseImageSource(ctx, sys, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing image source: %w", err)
+	}
+	defer src.Close()
+	rawManifest, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching manifest for image: %w", err)
+	}
+	imgDigest, err := manifest.Digest(rawManifest)
+	if err != nil {
+		return nil, fmt.Errorf("Error computing digest for image: %w", err)
+	}
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing manifest for image: %w", err)
+	}
+	myImg, err := img.OCIConfig(ctx)
+	// imgInspect, err := img.Inspect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error inspecting image: %w", err)
+	}
+	return &Info{
+		WorkingDir: myImg.Config.WorkingDir,
+		Entrypoint: myImg.Config.Entrypoint,
+		Cmd:        myImg.Config.Cmd,
+		Digest:     imgDigest,
+	}, nil
+}
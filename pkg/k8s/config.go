@@ -0,0 +1,160 @@
+// Package k8s holds the helpers devpod uses to connect to a cluster and to turn
+// flag values into Kubernetes API objects.
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	//
+	// Uncomment to load all auth plugins
+	// _ "k8s.io/client-go/plugin/pkg/client/auth"
+	//
+	// Or uncomment to load specific auth plugins
+	// _ "k8s.io/client-go/plugin/pkg/client/auth/azure"
+	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	// _ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+)
+
+// ClientOptions selects the cluster to talk to and how to authenticate.
+type ClientOptions struct {
+	// Kubeconfig is the path to the kubeconfig file, DefaultKubeconfig is
+	// used if it's empty.
+	Kubeconfig string
+
+	// Context is the kubeconfig context to use, the current context is used
+	// if it's empty.
+	Context string
+
+	// InCluster uses the service account of the pod devpod is running in
+	// instead of a kubeconfig.
+	InCluster bool
+
+	// Proxy is the URL of a proxy every API request is sent through, e.g. an
+	// auditing proxy. The proxy settings of the environment are used if it's
+	// empty.
+	Proxy string
+}
+
+// DefaultKubeconfig returns ~/.kube/config, or an empty string if there is no
+// home directory.
+func DefaultKubeconfig() string {
+	if home := homedir.HomeDir(); home != "" {
+		return filepath.Join(home, ".kube", "config")
+	}
+	return ""
+}
+
+func (o *ClientOptions) kubeconfig() string {
+	if o.Kubeconfig == "" {
+		return DefaultKubeconfig()
+	}
+	return o.Kubeconfig
+}
+
+// RESTConfig builds the client config for the selected cluster.
+func (o *ClientOptions) RESTConfig() (*rest.Config, error) {
+	var config *rest.Config
+	var err error
+	if o.InCluster {
+		config, err = rest.InClusterConfig()
+	} else {
+		// use the current context in kubeconfig unless a context was given
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: o.kubeconfig()},
+			&clientcmd.ConfigOverrides{
+				CurrentContext: o.Context,
+			}).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Proxy != "" {
+		proxyURL, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --kube-api-proxy %q: %w", o.Proxy, err)
+		}
+		// The proxy is set on the config rather than with a custom transport
+		// so client-go keeps building the TLS config from the kubeconfig.
+		config.Proxy = http.ProxyURL(proxyURL)
+	}
+	return config, nil
+}
+
+// Clientset creates a clientset for the selected cluster.
+func (o *ClientOptions) Clientset() (*kubernetes.Clientset, error) {
+	config, err := o.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// LoadCurrentNamespace returns the namespace of the kubeconfig context, the
+// current context is used if kubecontext is empty.
+func LoadCurrentNamespace(kubeconfig, kubecontext string) (string, error) {
+	kubectlconfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{
+			CurrentContext: kubecontext,
+		}).RawConfig()
+	if err != nil {
+		return "", err
+	}
+	// RawConfig ignores the overrides, so pick the context by hand.
+	currentContext := kubectlconfig.CurrentContext
+	if kubecontext != "" {
+		currentContext = kubecontext
+	}
+	ctx, ok := kubectlconfig.Contexts[currentContext]
+	if !ok {
+		return "", fmt.Errorf("current context %q from kubeconfig %q not found, this is a misconfiguration on your part", currentContext, kubeconfig)
+	}
+	return ctx.Namespace, nil
+}
+
+// inClusterNamespaceFile is where the namespace of the pod is mounted along
+// with the service account token.
+const inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LoadInClusterNamespace returns the namespace of the pod devpod is running in.
+func LoadInClusterNamespace() (string, error) {
+	namespace, err := os.ReadFile(inClusterNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the in-cluster namespace: %w", err)
+	}
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+// ResolveNamespace picks the namespace to use. An explicit namespace always
+// wins, then the namespace of the pod when running in-cluster or the namespace
+// of the kubeconfig context if useContext is set. Anything else falls back to
+// the "default" namespace, the same as kubectl.
+func (o *ClientOptions) ResolveNamespace(namespace string, useContext bool) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+	var err error
+	if o.InCluster {
+		namespace, err = LoadInClusterNamespace()
+	} else if useContext {
+		namespace, err = LoadCurrentNamespace(o.kubeconfig(), o.Context)
+	}
+	if err != nil {
+		return "", err
+	}
+	if namespace == "" {
+		namespace = v1.NamespaceDefault
+	}
+	return namespace, nil
+}
@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: local
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: dev
+  context:
+    cluster: local
+    user: admin
+    namespace: team-dev
+- name: staging
+  context:
+    cluster: local
+    user: admin
+    namespace: team-staging
+- name: no-namespace
+  context:
+    cluster: local
+    user: admin
+`
+
+// writeKubeconfig writes a kubeconfig to a temporary file and returns its path.
+func writeKubeconfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCurrentNamespace(t *testing.T) {
+	kubeconfig := writeKubeconfig(t, testKubeconfig)
+	tests := []struct {
+		name        string
+		kubecontext string
+		want        string
+	}{
+		{name: "current context", want: "team-dev"},
+		{name: "explicit context", kubecontext: "staging", want: "team-staging"},
+		{name: "empty namespace", kubecontext: "no-namespace", want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := LoadCurrentNamespace(kubeconfig, tc.kubecontext)
+			if err != nil {
+				t.Fatalf("LoadCurrentNamespace() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("LoadCurrentNamespace() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadCurrentNamespaceMissingContext(t *testing.T) {
+	kubeconfig := writeKubeconfig(t, testKubeconfig)
+	_, err := LoadCurrentNamespace(kubeconfig, "prod")
+	if err == nil {
+		t.Fatal("LoadCurrentNamespace() didn't fail for a missing context")
+	}
+	if !strings.Contains(err.Error(), `"prod"`) {
+		t.Errorf("error %q doesn't name the missing context", err)
+	}
+}
+
+func TestLoadCurrentNamespaceMissingCurrentContext(t *testing.T) {
+	kubeconfig := writeKubeconfig(t, strings.Replace(testKubeconfig, "current-context: dev", "current-context: gone", 1))
+	if _, err := LoadCurrentNamespace(kubeconfig, ""); err == nil {
+		t.Fatal("LoadCurrentNamespace() didn't fail for a missing current context")
+	}
+}
+
+func TestResolveNamespace(t *testing.T) {
+	opts := &ClientOptions{Kubeconfig: writeKubeconfig(t, testKubeconfig)}
+	tests := []struct {
+		name       string
+		context    string
+		namespace  string
+		useContext bool
+		want       string
+	}{
+		{name: "explicit namespace", namespace: "other", useContext: true, want: "other"},
+		{name: "context namespace", useContext: true, want: "team-dev"},
+		{name: "context ignored", want: "default"},
+		{name: "empty context namespace", context: "no-namespace", useContext: true, want: "default"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts.Context = tc.context
+			got, err := opts.ResolveNamespace(tc.namespace, tc.useContext)
+			if err != nil {
+				t.Fatalf("ResolveNamespace() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("ResolveNamespace() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
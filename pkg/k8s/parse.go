@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ParseFieldRefEnv turns NAME=fieldPath pairs into environment variables backed
+// by the Downward API.
+func ParseFieldRefEnv(vals []string) ([]v1.EnvVar, error) {
+	env := make([]v1.EnvVar, 0, len(vals))
+	for _, val := range vals {
+		envName, fieldPath, ok := strings.Cut(val, "=")
+		if !ok || envName == "" || fieldPath == "" {
+			return nil, fmt.Errorf("invalid field ref %q, expected NAME=fieldPath", val)
+		}
+		env = append(env, v1.EnvVar{
+			Name: envName,
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{FieldPath: fieldPath},
+			},
+		})
+	}
+	return env, nil
+}
+
+// ParseToleration parses a key:operator:value:effect string, the value may be
+// left empty when using the Exists operator, e.g. "gpu:Exists::NoSchedule".
+// The operator may be left empty too, the API treats that as Equal. The
+// key=value:effect form of kubectl taint is accepted as well, e.g.
+// "gpu=true:NoSchedule", the effect is optional and the operator is Equal.
+func ParseToleration(val string) (v1.Toleration, error) {
+	var toleration v1.Toleration
+	switch parts := strings.Split(val, ":"); len(parts) {
+	case 4:
+		toleration = v1.Toleration{
+			Key:      parts[0],
+			Operator: v1.TolerationOperator(parts[1]),
+			Value:    parts[2],
+			Effect:   v1.TaintEffect(parts[3]),
+		}
+	case 1, 2:
+		key, value, _ := strings.Cut(parts[0], "=")
+		if key == "" {
+			return v1.Toleration{}, fmt.Errorf("invalid toleration %q, expected key=value:effect", val)
+		}
+		toleration = v1.Toleration{Key: key, Operator: v1.TolerationOpEqual, Value: value}
+		if len(parts) == 2 {
+			toleration.Effect = v1.TaintEffect(parts[1])
+		}
+	default:
+		return v1.Toleration{}, fmt.Errorf("invalid toleration %q, expected key:operator:value:effect or key=value:effect", val)
+	}
+	switch toleration.Operator {
+	case "", v1.TolerationOpEqual, v1.TolerationOpExists:
+	default:
+		return v1.Toleration{}, fmt.Errorf("invalid toleration %q, operator must be %q or %q", val, v1.TolerationOpEqual, v1.TolerationOpExists)
+	}
+	switch toleration.Effect {
+	case "", v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return v1.Toleration{}, fmt.Errorf("invalid toleration %q, unknown effect %q", val, toleration.Effect)
+	}
+	return toleration, nil
+}
+
+// ParseNodeAffinity parses a mode,key=value[,key=value...] string where mode is
+// "required" or "preferred", e.g. "required,topology.kubernetes.io/zone=us-east-1a".
+// Every key=value pair becomes a requirement with the In operator, it reports
+// if the requirements are required.
+func ParseNodeAffinity(val string) (bool, []v1.NodeSelectorRequirement, error) {
+	parts := strings.Split(val, ",")
+	if len(parts) < 2 {
+		return false, nil, fmt.Errorf("invalid node affinity %q, expected required|preferred,key=value", val)
+	}
+	var required bool
+	switch parts[0] {
+	case "required":
+		required = true
+	case "preferred":
+	default:
+		return false, nil, fmt.Errorf("invalid node affinity %q, mode must be \"required\" or \"preferred\"", val)
+	}
+	reqs := make([]v1.NodeSelectorRequirement, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key == "" || value == "" {
+			return false, nil, fmt.Errorf("invalid node affinity %q, expected key=value but got %q", val, part)
+		}
+		reqs = append(reqs, v1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{value},
+		})
+	}
+	return required, reqs, nil
+}
@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		val  string
+		want v1.Toleration
+	}{
+		{"gpu:Exists::NoSchedule", v1.Toleration{Key: "gpu", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+		{"gpu:Equal:true:NoExecute", v1.Toleration{Key: "gpu", Operator: v1.TolerationOpEqual, Value: "true", Effect: v1.TaintEffectNoExecute}},
+		{"gpu::true:NoSchedule", v1.Toleration{Key: "gpu", Value: "true", Effect: v1.TaintEffectNoSchedule}},
+		{"gpu:Exists::", v1.Toleration{Key: "gpu", Operator: v1.TolerationOpExists}},
+		{"dedicated=devpod:NoSchedule", v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "devpod", Effect: v1.TaintEffectNoSchedule}},
+		{"dedicated=devpod", v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "devpod"}},
+		{"dedicated:PreferNoSchedule", v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Effect: v1.TaintEffectPreferNoSchedule}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.val, func(t *testing.T) {
+			got, err := ParseToleration(tc.val)
+			if err != nil {
+				t.Fatalf("ParseToleration() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("ParseToleration() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTolerationInvalid(t *testing.T) {
+	for _, val := range []string{
+		"",
+		"=devpod:NoSchedule",
+		"gpu:Maybe:true:NoSchedule",
+		"gpu:Equal:true:Never",
+		"dedicated=devpod:Never",
+		"a:b:c",
+	} {
+		if _, err := ParseToleration(val); err == nil {
+			t.Errorf("ParseToleration(%q) didn't fail", val)
+		}
+	}
+}